@@ -3,3 +3,18 @@ package request
 type PUTConfReq struct {
 	Conf string `json:"conf"` // "yaml配置"
 }
+
+type ListRunsReq struct {
+	Limit  int `form:"limit"`  // 每页数量,默认 20,最大 100
+	Offset int `form:"offset"` // 偏移量
+}
+
+type CleanupReq struct {
+	DryRun *bool `json:"dryRun"` // 只返回将要删除的证书,不会真正删除,默认为 true
+}
+
+type RenewalReq struct {
+	FatherDomain string   `json:"fatherDomain"` // 父域名,只指定父域名时处理该父域名下的所有域名
+	Domains      []string `json:"domains"`      // 需要处理的 CDN 域名
+	Force        bool     `json:"force"`        // 忽略过期检查,强制申请新证书
+}
@@ -1,5 +1,7 @@
 package response
 
+import "time"
+
 type Resp struct {
 	Code    int
 	Message string
@@ -9,3 +11,83 @@ type Resp struct {
 type GetConfResp struct {
 	Conf string `json:"conf"`
 }
+
+type GetScheduleResp struct {
+	Spec       string    `json:"spec"`       // cron 表达式
+	TimeZone   string    `json:"timeZone"`   // 时区
+	Jitter     string    `json:"jitter"`     // 随机推迟的最大时长
+	RunOnStart bool      `json:"runOnStart"` // 启动后是否立即执行
+	NextRun    time.Time `json:"nextRun"`    // 下一次计划执行的时间
+}
+
+type RunResp struct {
+	ID        uint                `json:"id"`
+	Trigger   string              `json:"trigger"`   // 触发方式
+	Status    string              `json:"status"`    // running/success/failed
+	StartedAt time.Time           `json:"startedAt"` // 开始时间
+	EndedAt   *time.Time          `json:"endedAt"`   // 结束时间
+	Error     string              `json:"error"`     // 整轮失败时的错误信息
+	Skipped   []SkippedDomainResp `json:"skipped"`   // 被过滤规则跳过的域名
+	Groups    []RunGroupResp      `json:"groups"`    // 各父域名分组的结果
+}
+
+type SkippedDomainResp struct {
+	Domain string `json:"domain"`
+	Reason string `json:"reason"` // 跳过的原因
+}
+
+type RunGroupResp struct {
+	FatherDomain  string   `json:"fatherDomain"`
+	CertName      string   `json:"certName"`      // 证书名称,未拆分的分组与父域名相同
+	Domains       []string `json:"domains"`       // 本轮需要处理的域名
+	FailedDomains []string `json:"failedDomains"` // 处理失败的域名
+	Stage         string   `json:"stage"`         // 责任链到达的阶段
+	Code          int      `json:"code"`          // 责任链返回的 code
+	Attempts      int      `json:"attempts"`      // 执行的次数,包含重试
+	Error         string   `json:"error"`
+	OldCertID     string   `json:"oldCertId"`
+	CertID        string   `json:"certId"`
+	Issued        bool     `json:"issued"` // 本轮是否申请了新证书
+}
+
+type ListRunsResp struct {
+	Total int64     `json:"total"`
+	Runs  []RunResp `json:"runs"`
+}
+
+type PlanResp struct {
+	Groups []PlanGroupResp `json:"groups"`
+}
+
+type PlanGroupResp struct {
+	FatherDomain  string   `json:"fatherDomain"`
+	CertName      string   `json:"certName"`      // 证书名称,未拆分的分组与父域名相同
+	SANs          []string `json:"sans"`          // 覆盖本轮域名所需的证书名称
+	Domains       []string `json:"domains"`       // 本轮需要处理的域名
+	CurrentCertID string   `json:"currentCertId"` // 当前仍然可用的证书 id
+	ObtainCert    bool     `json:"obtainCert"`    // 是否会申请新证书
+	UploadCert    bool     `json:"uploadCert"`    // 是否会上传新证书
+	SSLizeDomains []string `json:"sslizeDomains"` // 将要绑定证书的域名
+	RemoveCertID  string   `json:"removeCertId"`  // 将要删除的旧证书 id
+	DNSProvider   string   `json:"dnsProvider"`   // 申请证书时 DNS-01 验证使用的服务商
+	Reason        string   `json:"reason"`
+	Error         string   `json:"error"`
+}
+
+type RenewalResp struct {
+	JobID uint `json:"jobId"` // 任务 id,可以通过 /renewals/{id} 轮询进度
+}
+
+type CleanupResp struct {
+	DryRun  bool              `json:"dryRun"`  // 是否为预演
+	Deleted []CleanupCertResp `json:"deleted"` // 已删除(或预演时将要删除)的证书
+	Kept    []CleanupCertResp `json:"kept"`    // 保留的证书
+	Failed  []CleanupCertResp `json:"failed"`  // 删除失败的证书
+}
+
+type CleanupCertResp struct {
+	CertID   string    `json:"certId"`
+	Name     string    `json:"name"`
+	NotAfter time.Time `json:"notAfter"` // 证书过期时间
+	Reason   string    `json:"reason"`   // 删除或保留的原因,删除失败时为错误信息
+}
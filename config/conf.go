@@ -32,21 +32,186 @@ type EmailConf struct {
 }
 
 type QiniuConf struct {
-	AccessKey string `yaml:"accessKey"`
-	SecretKey string `yaml:"secretKey"`
-	Changed   bool   // 记录是否发生变更
+	AccessKey        string        `yaml:"accessKey"`
+	SecretKey        string        `yaml:"secretKey"`
+	BaseURL          string        `yaml:"baseURL"`          // API 地址,为空时使用 https://api.qiniu.com
+	Timeout          time.Duration `yaml:"timeout"`          // 单次请求的超时时间,为 0 时使用默认值
+	Proxy            string        `yaml:"proxy"`            // 出站代理,为空时读取 HTTP_PROXY 等环境变量
+	UserAgent        string        `yaml:"userAgent"`        // 请求的 User-Agent
+	PollInterval     time.Duration `yaml:"pollInterval"`     // 绑定证书后查询域名状态的间隔,为 0 时使用默认值
+	OperationTimeout time.Duration `yaml:"operationTimeout"` // 等待单个域名修改生效的最长时间,为 0 时使用默认值
+	RateLimit        RateLimitConf `yaml:"rateLimit"`
+	Changed          bool          // 记录是否发生变更
+}
+
+// RateLimitConf 七牛云接口的客户端限流配置,未配置的接口族使用默认值
+type RateLimitConf struct {
+	Domain     TokenBucketConf `yaml:"domain"`     // 域名查询接口
+	SSLCert    TokenBucketConf `yaml:"sslcert"`    // 证书的增删查接口
+	SSLize     TokenBucketConf `yaml:"sslize"`     // 为域名绑定证书的接口
+	MaxRetries *int            `yaml:"maxRetries"` // 遇到 429/573 时最多重试的次数
+}
+
+// TokenBucketConf 令牌桶配置
+type TokenBucketConf struct {
+	QPS   float64 `yaml:"qps"`   // 每秒允许的请求数
+	Burst int     `yaml:"burst"` // 桶容量
 }
 
 type SSLConf struct {
 	Email    string        `yaml:"email"`
-	Duration time.Duration `yaml:"duration"`
+	Duration time.Duration `yaml:"duration"` // 未配置 schedule.spec 时每轮之间的间隔
+	Schedule ScheduleConf  `yaml:"schedule"`
 	SSLPath  string        `yaml:"sslPath"`
 	Aliyun   struct {
 		AccessKeyID     string `yaml:"accessKeyID"`
 		AccessKeySecret string `yaml:"accessKeySecret"`
 	} `yaml:"aliyun"`
-	DB      string `yaml:"db"`
-	Changed bool   // 记录是否发生变更
+	DB          string           `yaml:"db"`
+	ACME        ACMEConf         `yaml:"acme"`
+	DNS         DNSConf          `yaml:"dns"`
+	Concurrency ConcurrencyConf  `yaml:"concurrency"`
+	Retry       RetryConf        `yaml:"retry"`
+	Renewal     RenewalConf      `yaml:"renewal"`
+	Domains     DomainFilterConf `yaml:"domains"`
+	HTTPS       HTTPSConf        `yaml:"https"`
+	Key         KeyConf          `yaml:"key"`
+	Cleanup     CleanupConf      `yaml:"cleanup"`
+	MaxSANs     int              `yaml:"maxSANs"` // 单张证书最多包含的名称数量,超出时拆分为多张证书,为 0 时为 100
+	Changed     bool             // 记录是否发生变更
+}
+
+// DNSConf DNS-01 验证使用的服务商,未声明 providers 时使用 aliyun 中的凭证
+type DNSConf struct {
+	Providers []DNSProviderConf `yaml:"providers"` // 按名称声明的服务商凭证
+	Zones     []DNSZoneConf     `yaml:"zones"`     // 父域名到服务商的映射,按顺序取第一个匹配的
+	Default   string            `yaml:"default"`   // 没有匹配的映射时使用的服务商,只声明了一个服务商时可以不填
+}
+
+// DNSProviderConf 单个 DNS 服务商的凭证
+type DNSProviderConf struct {
+	Name            string `yaml:"name"`            // 服务商名称,在 zones 和 default 中引用
+	Platform        string `yaml:"platform"`        // aliyun、tencent 或 cloudflare
+	AccessKeyID     string `yaml:"accessKeyID"`     // aliyun、tencent 使用
+	AccessKeySecret string `yaml:"accessKeySecret"` // aliyun、tencent 使用
+	Token           string `yaml:"token"`           // cloudflare 使用的 API Token
+}
+
+// DNSZoneConf 父域名使用的服务商,parent 默认按 glob 匹配,以 "re:" 开头时按正则表达式匹配
+type DNSZoneConf struct {
+	Parent   string `yaml:"parent"`
+	Provider string `yaml:"provider"`
+}
+
+// ACMEConf 申请证书使用的 CA,主签发者失败时按顺序尝试 fallbacks
+type ACMEConf struct {
+	ACMEIssuerConf `yaml:",inline" mapstructure:",squash"`
+	Fallbacks      []ACMEIssuerConf `yaml:"fallbacks"` // 备用的签发者
+}
+
+// ACMEIssuerConf 单个 ACME 签发者的配置
+type ACMEIssuerConf struct {
+	CA           string  `yaml:"ca"`           // letsencrypt(默认)、zerossl、google,或者 ACME 目录地址
+	Staging      bool    `yaml:"staging"`      // 使用 CA 的测试环境,签发的证书不受信任,只用于调试
+	EAB          EABConf `yaml:"eab"`          // External Account Binding,ZeroSSL、Google 等 CA 需要
+	TrustedRoots string  `yaml:"trustedRoots"` // 访问 CA 时额外信任的根证书 PEM 文件,用于 Pebble 等自建 CA
+}
+
+// EABConf External Account Binding 的凭证,由 CA 提供
+type EABConf struct {
+	KeyID  string `yaml:"keyID"`
+	MACKey string `yaml:"macKey"`
+}
+
+// ScheduleConf 续期任务的调度配置
+type ScheduleConf struct {
+	Spec       string        `yaml:"spec"`       // 标准 cron 表达式,如 "0 3 * * *"
+	TimeZone   string        `yaml:"timeZone"`   // cron 表达式使用的时区,如 "Asia/Shanghai",为空时使用本地时区
+	Jitter     time.Duration `yaml:"jitter"`     // 在计划时间上随机推迟的最大时长,为 0 时不推迟
+	RunOnStart bool          `yaml:"runOnStart"` // 启动后是否立即执行一轮
+}
+
+// ConcurrencyConf 各阶段的并发上限,未配置时使用默认值
+type ConcurrencyConf struct {
+	Groups int `yaml:"groups"` // 同时处理的父域名分组数量
+	Obtain int `yaml:"obtain"` // 同时向 ACME 申请证书的数量
+	Qiniu  int `yaml:"qiniu"`  // 同时对七牛云发起写操作(上传、绑定、删除证书)的数量
+}
+
+// RetryConf 分组失败后的重试策略,未配置时使用默认值
+type RetryConf struct {
+	MaxAttempts int                    `yaml:"maxAttempts"` // 每个分组最多执行的次数(包含第一次)
+	Backoff     BackoffConf            `yaml:"backoff"`     // 默认的退避策略
+	Stages      map[string]BackoffConf `yaml:"stages"`      // 按失败的阶段覆盖退避策略,key 为阶段名,如 obtainCert
+}
+
+// BackoffConf 指数退避策略
+type BackoffConf struct {
+	Initial    time.Duration `yaml:"initial"`    // 第一次重试前的等待时间
+	Max        time.Duration `yaml:"max"`        // 等待时间的上限
+	Multiplier float64       `yaml:"multiplier"` // 每次失败后等待时间的倍数
+}
+
+// RenewalConf 续期策略
+type RenewalConf struct {
+	Default   RenewalPolicy            `yaml:"default"`   // 全局默认策略
+	Overrides map[string]RenewalPolicy `yaml:"overrides"` // 按父域名覆盖,key 为父域名,覆盖时整体替换默认策略
+}
+
+// RenewalPolicy 证书何时需要续期,两个字段同时配置时任意一个满足即续期,都未配置时默认过期前 30 天续期
+type RenewalPolicy struct {
+	DaysBefore       int     `yaml:"daysBefore"`       // 距离过期不足多少天时续期
+	LifetimeFraction float64 `yaml:"lifetimeFraction"` // 有效期过去多少比例后续期,取值 (0,1),如 0.67 表示用掉 2/3 后续期
+}
+
+// HTTPSConf 绑定证书时的 https 选项
+type HTTPSConf struct {
+	Default   HTTPSPolicy            `yaml:"default"`   // 全局默认选项
+	Overrides map[string]HTTPSPolicy `yaml:"overrides"` // 按域名或父域名覆盖,域名优先
+}
+
+// HTTPSPolicy 单个域名的 https 选项,覆盖配置中未填写的字段继承默认选项
+type HTTPSPolicy struct {
+	Mode        string `yaml:"mode"`        // preserve(默认):未指定的选项沿用域名当前的设置;set:未指定的选项一律关闭
+	ForceHttps  *bool  `yaml:"forceHttps"`  // 是否强制 http 跳转 https
+	Http2Enable *bool  `yaml:"http2Enable"` // 是否开启 http2
+}
+
+// KeyConf 申请证书时使用的私钥,修改后在下一次续期时生效,可以通过强制续期立即生效
+type KeyConf struct {
+	Default   KeyPolicy            `yaml:"default"`   // 全局默认配置
+	Overrides map[string]KeyPolicy `yaml:"overrides"` // 按父域名覆盖,key 为父域名
+}
+
+// KeyPolicy 私钥类型与编码格式,覆盖配置中未填写的字段继承默认配置
+type KeyPolicy struct {
+	Type  string `yaml:"type"`  // rsa2048、rsa4096、p256(默认)、p384
+	PKCS8 *bool  `yaml:"pkcs8"` // 是否以 PKCS#8 编码私钥,默认 RSA 使用 PKCS#1,ECDSA 使用 SEC 1
+}
+
+// CleanupConf 清理七牛云上无用证书的配置
+type CleanupConf struct {
+	Enabled bool          `yaml:"enabled"` // 是否在每轮定时任务结束后清理
+	DryRun  bool          `yaml:"dryRun"`  // 只在日志中列出将要删除的证书,不会真正删除
+	Grace   time.Duration `yaml:"grace"`   // 上传不足该时长的证书不会被清理,为 0 时为 24h
+	Protect []string      `yaml:"protect"` // 永远不会被删除的证书,可以是证书 id 或匹配证书名称的 path.Match 通配符
+	OnlyOwn bool          `yaml:"onlyOwn"` // 只删除本工具上传的证书,不删除其他已过期且没有绑定的证书
+}
+
+// DomainFilterConf 七牛云上哪些域名由本服务管理,exclude 优先于 include,include 为空时管理所有未被排除的域名
+type DomainFilterConf struct {
+	Include []DomainRule `yaml:"include"`
+	Exclude []DomainRule `yaml:"exclude"`
+}
+
+// DomainRule 单条过滤规则,所有配置了的字段都匹配时规则才命中,
+// 字段默认按 glob 匹配(如 "*.example.com"),以 "re:" 开头时按正则表达式匹配
+type DomainRule struct {
+	Domain   string `yaml:"domain"`   // 域名
+	Parent   string `yaml:"parent"`   // 父域名
+	Type     string `yaml:"type"`     // 七牛云域名类型,如 normal、wildcard、test
+	Protocol string `yaml:"protocol"` // 访问协议,http 或 https
+	Platform string `yaml:"platform"` // 使用场景,如 web、download、vod
 }
 
 type CronConf struct {
@@ -200,9 +365,7 @@ func LoadConfigFromYAML(yamlData string) (*CronConf, error) {
 
 // GetAllConfigsAsYAML 获取所有配置并转换为 YAML 字符串
 func GetAllConfigsAsYAML() (string, error) {
-	mu.Lock()
-	defer mu.Unlock()
-
+	//GetCronConfig 内部已经加锁,这里不能重复加锁
 	config := GetCronConfig()
 
 	// 序列化成 YAML 格式
@@ -1,16 +1,31 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/muxi-Infra/autossl-qiniuyun/api/request"
 	"github.com/muxi-Infra/autossl-qiniuyun/api/response"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/service"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // IService 定义 Service 层接口
 type IService interface {
 	GetAllConfigsAsYAML() (string, error)
 	OverwriteConfigsFromYAML(yamlConfig string) error
+	GetSchedule() (config.ScheduleConf, time.Time)
+	ListRuns(limit, offset int) ([]dao.Run, int64, error)
+	GetRun(id uint) (*dao.Run, error)
+	GetRenewal(id uint) (*dao.Run, error)
+	Plan(ctx context.Context) ([]cron.GroupPlan, error)
+	Renew(fatherDomain string, domains []string, force bool) (uint, error)
+	Cleanup(ctx context.Context, dryRun bool) (*cron.CleanupReport, error)
 }
 
 // Controller 结构体
@@ -32,6 +47,23 @@ func (c *Controller) RegisterRoutes(router *gin.RouterGroup) {
 		api.GET("/yaml", c.GetAllConfigsAsYAML)
 		api.PUT("/yaml", c.OverwriteConfigsFromYAML)
 	}
+	router.GET("/schedule", c.GetSchedule)
+
+	runs := router.Group("/runs")
+	{
+		runs.GET("", c.ListRuns)
+		runs.GET("/:id", c.GetRun)
+	}
+
+	router.GET("/plan", c.Plan)
+
+	renewals := router.Group("/renewals")
+	{
+		renewals.POST("", c.Renew)
+		renewals.GET("/:id", c.GetRenewal)
+	}
+
+	router.POST("/cleanup", c.Cleanup)
 }
 
 // GetAllConfigsAsYAML 获取当前配置的 YAML 内容
@@ -95,3 +127,319 @@ func (c *Controller) OverwriteConfigsFromYAML(ctx *gin.Context) {
 		Message: "更新配置成功!",
 	})
 }
+
+// GetSchedule 获取续期任务的调度信息
+// @Summary 获取调度信息
+// @Description 返回当前的 cron 调度配置以及下一次计划执行的时间
+// @Tags 调度管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} response.Resp{data=response.GetScheduleResp} "获取成功"
+// @Router /schedule [get]
+func (c *Controller) GetSchedule(ctx *gin.Context) {
+	conf, next := c.service.GetSchedule()
+	ctx.JSON(http.StatusOK, response.Resp{
+		Code:    0,
+		Message: "获取调度信息成功!",
+		Data: response.GetScheduleResp{
+			Spec:       conf.Spec,
+			TimeZone:   conf.TimeZone,
+			Jitter:     conf.Jitter.String(),
+			RunOnStart: conf.RunOnStart,
+			NextRun:    next,
+		},
+	})
+}
+
+// ListRuns 分页获取续期执行记录
+// @Summary 获取执行记录列表
+// @Description 按时间倒序返回每一轮续期任务的执行记录
+// @Tags 执行记录
+// @Accept json
+// @Produce json
+// @Param limit query int false "每页数量,默认 20,最大 100"
+// @Param offset query int false "偏移量"
+// @Success 200 {object} response.Resp{data=response.ListRunsResp} "获取成功"
+// @Failure 400 {object} response.Resp "请求格式错误"
+// @Failure 500 {object} response.Resp "服务器错误"
+// @Router /runs [get]
+func (c *Controller) ListRuns(ctx *gin.Context) {
+	var req request.ListRunsReq
+	if err := ctx.ShouldBindQuery(&req); err != nil || req.Limit < 0 || req.Offset < 0 {
+		ctx.JSON(http.StatusBadRequest, response.Resp{
+			Code:    40001,
+			Message: "请求格式错误!",
+		})
+		return
+	}
+	if req.Limit == 0 {
+		req.Limit = 20
+	}
+	if req.Limit > 100 {
+		req.Limit = 100
+	}
+
+	runs, total, err := c.service.ListRuns(req.Limit, req.Offset)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, response.Resp{
+			Code:    50002,
+			Message: "获取执行记录失败!",
+		})
+		return
+	}
+
+	resp := response.ListRunsResp{Total: total, Runs: make([]response.RunResp, 0, len(runs))}
+	for _, run := range runs {
+		resp.Runs = append(resp.Runs, toRunResp(&run))
+	}
+	ctx.JSON(http.StatusOK, response.Resp{
+		Code:    0,
+		Message: "获取执行记录成功!",
+		Data:    resp,
+	})
+}
+
+// GetRun 获取单条续期执行记录
+// @Summary 获取执行记录详情
+// @Description 返回某一轮续期任务中每个父域名分组的处理结果
+// @Tags 执行记录
+// @Accept json
+// @Produce json
+// @Param id path int true "执行记录 id"
+// @Success 200 {object} response.Resp{data=response.RunResp} "获取成功"
+// @Failure 400 {object} response.Resp "请求格式错误"
+// @Failure 404 {object} response.Resp "执行记录不存在"
+// @Failure 500 {object} response.Resp "服务器错误"
+// @Router /runs/{id} [get]
+func (c *Controller) GetRun(ctx *gin.Context) {
+	c.getRun(ctx, c.service.GetRun)
+}
+
+// GetRenewal 获取手动续期任务的进度
+// @Summary 获取手动续期任务进度
+// @Description 返回手动续期任务中每个分组的处理结果,只能查询通过 /renewals 创建的任务
+// @Tags 续期管理
+// @Accept json
+// @Produce json
+// @Param id path int true "任务 id"
+// @Success 200 {object} response.Resp{data=response.RunResp} "获取成功"
+// @Failure 400 {object} response.Resp "请求格式错误"
+// @Failure 404 {object} response.Resp "任务不存在"
+// @Failure 500 {object} response.Resp "服务器错误"
+// @Router /renewals/{id} [get]
+func (c *Controller) GetRenewal(ctx *gin.Context) {
+	c.getRun(ctx, c.service.GetRenewal)
+}
+
+// getRun 按路径中的 id 查询执行记录并返回
+func (c *Controller) getRun(ctx *gin.Context, get func(id uint) (*dao.Run, error)) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, response.Resp{
+			Code:    40001,
+			Message: "请求格式错误!",
+		})
+		return
+	}
+
+	run, err := get(uint(id))
+	switch {
+	case errors.Is(err, service.ErrRunNotFound):
+		ctx.JSON(http.StatusNotFound, response.Resp{
+			Code:    40401,
+			Message: "执行记录不存在!",
+		})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, response.Resp{
+			Code:    50002,
+			Message: "获取执行记录失败!",
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.Resp{
+		Code:    0,
+		Message: "获取执行记录成功!",
+		Data:    toRunResp(run),
+	})
+}
+
+// toRunResp 将数据库中的执行记录转换为响应结构
+func toRunResp(run *dao.Run) response.RunResp {
+	resp := response.RunResp{
+		ID:        run.ID,
+		Trigger:   run.Trigger,
+		Status:    run.Status,
+		StartedAt: run.StartedAt,
+		EndedAt:   run.EndedAt,
+		Error:     run.Error,
+		Skipped:   make([]response.SkippedDomainResp, 0, len(run.Skipped)),
+		Groups:    make([]response.RunGroupResp, 0, len(run.Groups)),
+	}
+	for _, s := range run.Skipped {
+		resp.Skipped = append(resp.Skipped, response.SkippedDomainResp{Domain: s.Domain, Reason: s.Reason})
+	}
+	for _, g := range run.Groups {
+		resp.Groups = append(resp.Groups, response.RunGroupResp{
+			FatherDomain:  g.FatherDomain,
+			CertName:      g.CertName,
+			Domains:       g.Domains,
+			FailedDomains: g.FailedDomains,
+			Stage:         g.Stage,
+			Code:          g.Code,
+			Attempts:      g.Attempts,
+			Error:         g.Error,
+			OldCertID:     g.OldCertID,
+			CertID:        g.CertID,
+			Issued:        g.Issued,
+		})
+	}
+	return resp
+}
+
+// Plan 预演一轮续期任务
+// @Summary 预演续期任务
+// @Description 按证书分组返回本轮将要申请、上传、绑定和删除的证书,不会做任何修改
+// @Tags 续期管理
+// @Accept json
+// @Produce json
+// @Success 200 {object} response.Resp{data=response.PlanResp} "预演成功"
+// @Failure 500 {object} response.Resp "服务器错误"
+// @Router /plan [get]
+func (c *Controller) Plan(ctx *gin.Context) {
+	plans, err := c.service.Plan(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, response.Resp{
+			Code:    50003,
+			Message: "预演失败: " + err.Error(),
+		})
+		return
+	}
+
+	resp := response.PlanResp{Groups: make([]response.PlanGroupResp, 0, len(plans))}
+	for _, p := range plans {
+		resp.Groups = append(resp.Groups, response.PlanGroupResp{
+			FatherDomain:  p.FatherDomain,
+			CertName:      p.CertName,
+			SANs:          p.SANs,
+			Domains:       p.Domains,
+			CurrentCertID: p.CurrentCertId,
+			ObtainCert:    p.ObtainCert,
+			UploadCert:    p.UploadCert,
+			SSLizeDomains: p.SSLizeDomains,
+			RemoveCertID:  p.RemoveCertId,
+			DNSProvider:   p.DNSProvider,
+			Reason:        p.Reason,
+			Error:         p.Error,
+		})
+	}
+	ctx.JSON(http.StatusOK, response.Resp{
+		Code:    0,
+		Message: "预演成功!",
+		Data:    resp,
+	})
+}
+
+// Renew 立即续期
+// @Summary 立即续期
+// @Description 对指定的父域名或域名列表立即执行续期流程,返回可以轮询进度的任务 id
+// @Tags 续期管理
+// @Accept json
+// @Produce json
+// @Param request body request.RenewalReq true "续期请求"
+// @Success 200 {object} response.Resp{data=response.RenewalResp} "任务已创建"
+// @Failure 400 {object} response.Resp "请求格式错误"
+// @Failure 500 {object} response.Resp "服务器错误"
+// @Router /renewals [post]
+func (c *Controller) Renew(ctx *gin.Context) {
+	var req request.RenewalReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, response.Resp{
+			Code:    40001,
+			Message: "请求格式错误!",
+		})
+		return
+	}
+
+	id, err := c.service.Renew(req.FatherDomain, req.Domains, req.Force)
+	switch {
+	case errors.Is(err, cron.ErrInvalidRenewal):
+		ctx.JSON(http.StatusBadRequest, response.Resp{
+			Code:    40002,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		ctx.JSON(http.StatusInternalServerError, response.Resp{
+			Code:    50004,
+			Message: "创建续期任务失败: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.Resp{
+		Code:    0,
+		Message: "续期任务已创建!",
+		Data:    response.RenewalResp{JobID: id},
+	})
+}
+
+// Cleanup 清理无用证书
+// @Summary 清理无用证书
+// @Description 删除七牛云上已过期或由本工具上传、且没有绑定任何域名的证书(开启 onlyOwn 时只删除本工具上传的证书),默认只预演,dryRun 为 false 时才会真正删除
+// @Tags 续期管理
+// @Accept json
+// @Produce json
+// @Param request body request.CleanupReq false "清理请求"
+// @Success 200 {object} response.Resp{data=response.CleanupResp} "清理成功"
+// @Failure 400 {object} response.Resp "请求格式错误"
+// @Failure 500 {object} response.Resp "服务器错误"
+// @Router /cleanup [post]
+func (c *Controller) Cleanup(ctx *gin.Context) {
+	var req request.CleanupReq
+	if ctx.Request.ContentLength != 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, response.Resp{
+				Code:    40001,
+				Message: "请求格式错误!",
+			})
+			return
+		}
+	}
+	dryRun := req.DryRun == nil || *req.DryRun
+
+	report, err := c.service.Cleanup(ctx.Request.Context(), dryRun)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, response.Resp{
+			Code:    50005,
+			Message: "清理证书失败: " + err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, response.Resp{
+		Code:    0,
+		Message: "清理成功!",
+		Data: response.CleanupResp{
+			DryRun:  report.DryRun,
+			Deleted: toCleanupCertResp(report.Deleted),
+			Kept:    toCleanupCertResp(report.Kept),
+			Failed:  toCleanupCertResp(report.Failed),
+		},
+	})
+}
+
+// toCleanupCertResp 将清理结果转换为接口返回的格式
+func toCleanupCertResp(certs []cron.CleanupCert) []response.CleanupCertResp {
+	resp := make([]response.CleanupCertResp, 0, len(certs))
+	for _, c := range certs {
+		resp = append(resp, response.CleanupCertResp{
+			CertID:   c.CertID,
+			Name:     c.Name,
+			NotAfter: c.NotAfter,
+			Reason:   c.Reason,
+		})
+	}
+	return resp
+}
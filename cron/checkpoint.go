@@ -0,0 +1,126 @@
+package cron
+
+import (
+	"context"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"gorm.io/gorm"
+	"log"
+)
+
+// saveCheckpoint 保存分组即将进入的阶段,保存失败只打印日志,不影响续期流程
+func (e *env) saveCheckpoint(d *DomainWithCert, stage int) {
+	if e.cps == nil {
+		return
+	}
+
+	err := e.cps.SaveCheckpoint(&dao.Checkpoint{
+		FatherDomain: d.FatherDomain,
+		CertName:     d.CertName,
+		Stage:        stage,
+		Domains:      d.Domains,
+		OldCertID:    d.OldCertId,
+		CertID:       d.CertId,
+		CertPEM:      d.CertPEM,
+		KeyPEM:       d.KeyPEM,
+		Force:        d.Force,
+	})
+	if err != nil {
+		log.Printf("保存 %s 的处理进度失败: %v\n", d.CertName, err)
+	}
+}
+
+// clearCheckpoint 分组处理完成后清除进度
+func (e *env) clearCheckpoint(d *DomainWithCert) {
+	if e.cps == nil {
+		return
+	}
+
+	if err := e.cps.DeleteCheckpoint(d.CertName); err != nil {
+		log.Printf("清除 %s 的处理进度失败: %v\n", d.CertName, err)
+	}
+}
+
+// checkpointDomain 把保存的进度还原为责任链处理的分组
+func checkpointDomain(cp *dao.Checkpoint) *DomainWithCert {
+	return &DomainWithCert{
+		Domains:      cp.Domains,
+		FatherDomain: cp.FatherDomain,
+		CertName:     cp.CertName,
+		OldCertId:    cp.OldCertID,
+		CertId:       cp.CertID,
+		CertPEM:      cp.CertPEM,
+		KeyPEM:       cp.KeyPEM,
+		Force:        cp.Force,
+	}
+}
+
+// groupItem 返回分组本轮的执行项。上一轮重试耗尽的分组如果已经申请了新证书,
+// 则从保存的阶段继续,避免重新申请和上传证书;尚未申请新证书时从头开始即可
+func (e *env) groupItem(g certGroup, force bool) *retryItem {
+	item := &retryItem{
+		domain: &DomainWithCert{
+			Domains:      g.Domains,
+			FatherDomain: g.FatherDomain,
+			CertName:     g.CertName,
+			Force:        force,
+		},
+		code: StartAll,
+	}
+	if e.cps == nil {
+		return item
+	}
+
+	cp, err := e.cps.GetCheckpoint(g.CertName)
+	switch {
+	case err == gorm.ErrRecordNotFound:
+		return item
+	case err != nil:
+		log.Printf("读取 %s 的处理进度失败,从头开始处理: %v\n", g.CertName, err)
+		return item
+	case cp.CertPEM == "":
+		return item
+	}
+
+	d := checkpointDomain(cp)
+	d.Force = d.Force || force
+	//本轮新增且新证书能够覆盖的域名一起处理,其余域名等这次进度完成后的下一轮再处理
+	if info, err := dao.ParseCertPEM(cp.CertPEM); err == nil {
+		for _, name := range filterUnstoredDomains(g.Domains, d.Domains) {
+			if covers(info.SANs, name) {
+				d.Domains = append(d.Domains, name)
+			}
+		}
+	}
+	log.Printf("%s 存在未完成的处理进度,从 %s 阶段继续\n", g.CertName, StageName(cp.Stage))
+	return &retryItem{domain: d, code: cp.Stage}
+}
+
+// resume 从记录的阶段继续处理上次未完成的分组
+func (q *QiniuSSL) resume(ctx, work context.Context) {
+	e := currentEnv()
+	if e.cps == nil {
+		return
+	}
+	cps, err := e.cps.ListCheckpoints()
+	if err != nil {
+		log.Println("读取未完成的处理进度失败:", err)
+		return
+	}
+	if len(cps) == 0 {
+		return
+	}
+
+	items := make([]*retryItem, 0, len(cps))
+	for _, cp := range cps {
+		log.Printf("继续处理 %s,从 %s 阶段开始\n", cp.CertName, StageName(cp.Stage))
+		items = append(items, &retryItem{domain: checkpointDomain(&cp), code: cp.Stage})
+	}
+
+	rec := newRunRecorder(e.runs, TriggerResume)
+	errs := processGroups(ctx, work, e, items, rec)
+	rec.finish(ctx.Err())
+
+	if len(errs) > 0 && ctx.Err() == nil {
+		e.alert("", q.generateErrorReportHTML(errs))
+	}
+}
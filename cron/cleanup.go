@@ -0,0 +1,231 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"path"
+	"strings"
+	"time"
+)
+
+// defaultCleanupGrace 未配置宽限期时,上传不足该时长的证书不会被清理
+const defaultCleanupGrace = 24 * time.Hour
+
+// CleanupCert 清理任务中单张证书的处理结果
+type CleanupCert struct {
+	CertID   string    `json:"certId"`
+	Name     string    `json:"name"`
+	NotAfter time.Time `json:"notAfter"` // 证书过期时间
+	Reason   string    `json:"reason"`   // 删除或保留的原因,删除失败时为错误信息
+}
+
+// CleanupReport 一次清理任务的结果
+type CleanupReport struct {
+	DryRun  bool          `json:"dryRun"`  // 为 true 时只列出将要删除的证书,不会真正删除
+	Deleted []CleanupCert `json:"deleted"` // 已删除(或预演时将要删除)的证书
+	Kept    []CleanupCert `json:"kept"`    // 保留的证书
+	Failed  []CleanupCert `json:"failed"`  // 删除失败的证书
+}
+
+// Cleanup 清理七牛云上已过期或由本工具上传、且没有绑定任何域名的证书,配置 onlyOwn 时只清理本工具上传的证书
+func (q *QiniuSSL) Cleanup(ctx context.Context, dryRun bool) (*CleanupReport, error) {
+	if currentEnv().qiniu == nil {
+		q.initConfig()
+	}
+
+	e := currentEnv()
+	if e.qiniu == nil || e.ssl == nil || e.runs == nil || e.cps == nil {
+		return nil, errors.New("服务尚未初始化,请检查配置")
+	}
+	return e.cleanupCerts(ctx, dryRun)
+}
+
+// cleanupCerts 执行一次清理
+func (e *env) cleanupCerts(ctx context.Context, dryRun bool) (*CleanupReport, error) {
+	certs, err := e.qiniu.GETSSLCertList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("获取证书列表失败: %w", err)
+	}
+	bound, err := e.boundCerts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ours, inProgress, err := e.knownCerts()
+	if err != nil {
+		return nil, err
+	}
+
+	grace := e.cleanup.Grace
+	if grace <= 0 {
+		grace = defaultCleanupGrace
+	}
+
+	report := &CleanupReport{DryRun: dryRun}
+	now := time.Now()
+	var toDelete []CleanupCert
+	for _, cert := range certs.Certs {
+		item := CleanupCert{CertID: cert.CertId, Name: cert.Name, NotAfter: unixTime(cert.NotAfter)}
+		uploadedAt := unixTime(cert.CreateTime)
+		if uploadedAt.IsZero() {
+			uploadedAt = unixTime(cert.NotBefore)
+		}
+
+		remove := false
+		switch {
+		case e.isProtected(cert.CertId, cert.Name):
+			item.Reason = "在保护列表中"
+		case len(bound[cert.CertId]) > 0:
+			item.Reason = "仍绑定在域名上: " + strings.Join(bound[cert.CertId], ",")
+		case inProgress[cert.CertId]:
+			item.Reason = "所属分组尚未处理完成"
+		case now.Sub(uploadedAt) < grace:
+			item.Reason = "上传时间不足宽限期"
+		case ours[cert.CertId]:
+			item.Reason = "由本工具上传且没有绑定任何域名"
+			remove = true
+		case item.NotAfter.IsZero() || now.Before(item.NotAfter):
+			item.Reason = "不是本工具上传且尚未过期"
+		case e.cleanup.OnlyOwn:
+			item.Reason = "不是本工具上传的证书,配置了只清理本工具上传的证书"
+		default:
+			item.Reason = "已过期且没有绑定任何域名"
+			remove = true
+		}
+
+		if remove {
+			toDelete = append(toDelete, item)
+		} else {
+			report.Kept = append(report.Kept, item)
+		}
+	}
+
+	if dryRun {
+		report.Deleted = toDelete
+		return report, nil
+	}
+
+	for _, item := range toDelete {
+		if err := e.removeCert(ctx, item.CertID); err != nil {
+			item.Reason = err.Error()
+			report.Failed = append(report.Failed, item)
+			continue
+		}
+		report.Deleted = append(report.Deleted, item)
+	}
+	return report, nil
+}
+
+// boundCerts 查询七牛云上每张证书当前绑定的域名,key 为证书 id。
+// 这里会遍历账号下的所有域名(包括被过滤规则跳过的),避免删除其他人正在使用的证书
+func (e *env) boundCerts(ctx context.Context) (map[string][]string, error) {
+	bound := make(map[string][]string)
+	for domain, err := range e.qiniu.Domains(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("获取域名列表失败: %w", err)
+		}
+		if domain.Protocol != "https" {
+			continue
+		}
+		detail, err := e.qiniu.GetDomain(ctx, domain.Name)
+		if err != nil {
+			return nil, fmt.Errorf("获取域名 %s 的详情失败: %w", domain.Name, err)
+		}
+		if detail.HTTPS.CertID != "" {
+			bound[detail.HTTPS.CertID] = append(bound[detail.HTTPS.CertID], domain.Name)
+		}
+	}
+	return bound, nil
+}
+
+// knownCerts 返回本工具上传过的证书 id,以及尚未处理完成的分组正在使用的证书 id
+func (e *env) knownCerts() (ours, inProgress map[string]bool, err error) {
+	ours = make(map[string]bool)
+	inProgress = make(map[string]bool)
+
+	ssls, err := e.ssl.GetSSLS()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range *ssls {
+		ours[s.CertID] = true
+	}
+
+	ids, err := e.runs.ListCertIDs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range ids {
+		ours[id] = true
+	}
+
+	cps, err := e.cps.ListCheckpoints()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, cp := range cps {
+		for _, id := range []string{cp.CertID, cp.OldCertID} {
+			if id != "" {
+				ours[id] = true
+				inProgress[id] = true
+			}
+		}
+	}
+	return ours, inProgress, nil
+}
+
+// isProtected 判断证书是否在保护列表中,列表中的每一项可以是证书 id,也可以是匹配证书名称的 glob
+func (e *env) isProtected(certId, name string) bool {
+	for _, p := range e.cleanup.Protect {
+		if p == certId {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(p), strings.ToLower(name)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// removeCert 删除七牛云上的证书及本地记录
+func (e *env) removeCert(ctx context.Context, certId string) error {
+	if err := e.qiniuSem.acquire(ctx); err != nil {
+		return err
+	}
+	err := e.qiniu.RemoveSSLCert(ctx, certId)
+	e.qiniuSem.release()
+	if err != nil {
+		return err
+	}
+
+	err = e.ssl.DeleteSSL(certId)
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return err
+	}
+	return nil
+}
+
+// scheduledCleanup 在每轮定时任务结束后按配置执行清理,结果只写入日志
+func (e *env) scheduledCleanup(ctx context.Context) {
+	if !e.cleanup.Enabled {
+		return
+	}
+
+	report, err := e.cleanupCerts(ctx, e.cleanup.DryRun)
+	if err != nil {
+		log.Println("清理证书失败:", err)
+		return
+	}
+	for _, c := range report.Deleted {
+		if report.DryRun {
+			log.Printf("[预演] 将要删除证书 %s(%s): %s\n", c.CertID, c.Name, c.Reason)
+		} else {
+			log.Printf("已删除证书 %s(%s): %s\n", c.CertID, c.Name, c.Reason)
+		}
+	}
+	for _, c := range report.Failed {
+		log.Printf("删除证书 %s(%s) 失败: %s\n", c.CertID, c.Name, c.Reason)
+	}
+}
@@ -0,0 +1,83 @@
+package cron
+
+import (
+	"context"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu/qiniutest"
+	"testing"
+	"time"
+)
+
+func TestCleanupCerts(t *testing.T) {
+	tests := []struct {
+		name    string
+		onlyOwn bool
+		deleted []string
+	}{
+		{"default", false, []string{"ours", "expired"}},
+		{"only own", true, []string{"ours"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := qiniutest.NewServer("test-ak", "test-sk")
+			defer srv.Close()
+			e := useTestEnv(t, srv)
+			e.cleanup.Grace = time.Nanosecond
+			e.cleanup.OnlyOwn = tt.onlyOwn
+			e.cleanup.Protect = []string{"protected"}
+
+			now := time.Now()
+			add := func(name string, notAfter time.Time) (id, certPEM, keyPEM string) {
+				t.Helper()
+				certPEM, keyPEM, err := qiniutest.NewCertPEM([]string{name + ".example.com"}, notAfter.Add(-90*24*time.Hour), notAfter)
+				if err != nil {
+					t.Fatal(err)
+				}
+				id, err = srv.AddCert(name, certPEM, keyPEM)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return id, certPEM, keyPEM
+			}
+			expired, valid := now.Add(-24*time.Hour), now.Add(30*24*time.Hour)
+
+			//本工具上传、没有绑定的证书
+			ours, oursPEM, oursKey := add("ours", valid)
+			if err := e.ssl.CreateSSL("ours", ours, oursPEM, oursKey, nil); err != nil {
+				t.Fatal(err)
+			}
+			//其他来源的证书:已过期、尚未过期、已过期但仍绑定、已过期但受保护
+			add("expired", expired)
+			add("valid", valid)
+			bound, _, _ := add("bound", expired)
+			srv.AddDomain(qiniu.DomainDetail{
+				Domain: qiniu.Domain{Name: "a.example.com", Protocol: "https"},
+				HTTPS:  qiniu.HTTPSConf{CertID: bound},
+			})
+			add("protected", expired)
+
+			report, err := e.cleanupCerts(context.Background(), false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var deleted []string
+			for _, c := range report.Deleted {
+				deleted = append(deleted, c.Name)
+				if _, ok := srv.Cert(c.CertID); ok {
+					t.Errorf("cert %s was not removed", c.Name)
+				}
+			}
+			if len(report.Failed) > 0 {
+				t.Errorf("got failed %+v", report.Failed)
+			}
+			if len(deleted) != len(tt.deleted) {
+				t.Fatalf("got deleted %v, want %v", deleted, tt.deleted)
+			}
+			for i := range deleted {
+				if deleted[i] != tt.deleted[i] {
+					t.Fatalf("got deleted %v, want %v", deleted, tt.deleted)
+				}
+			}
+		})
+	}
+}
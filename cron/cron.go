@@ -1,7 +1,28 @@
 package cron
 
+import (
+	"context"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"time"
+)
+
 type Corn interface {
-	Start()
+	// Start 运行定时任务,直到 ctx 被取消且当前轮次结束后返回
+	Start(ctx context.Context)
+	// NextRun 返回下一次计划执行的时间
+	NextRun() time.Time
+	// Plan 预演一轮任务将要执行的操作,不会做任何修改
+	Plan(ctx context.Context) ([]GroupPlan, error)
+	// ListRuns 按时间倒序分页获取执行记录
+	ListRuns(limit, offset int) ([]dao.Run, int64, error)
+	// GetRun 获取单条执行记录
+	GetRun(id uint) (*dao.Run, error)
+	// Renew 在后台立即执行一次续期,返回可以轮询进度的任务 id
+	Renew(req RenewRequest) (uint, error)
+	// Wait 等待所有正在执行的手动续期任务结束
+	Wait()
+	// Cleanup 清理七牛云上已过期或由本工具上传、且没有绑定任何域名的证书,dryRun 为 true 时只返回将要删除的证书
+	Cleanup(ctx context.Context, dryRun bool) (*CleanupReport, error)
 }
 
 func NewCorn(q *QiniuSSL) Corn {
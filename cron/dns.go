@@ -0,0 +1,120 @@
+package cron
+
+import (
+	"errors"
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/ssl"
+	"strings"
+)
+
+// legacyDNSProvider 未声明 dns.providers 时,由 ssl.aliyun 生成的服务商名称
+const legacyDNSProvider = "aliyun"
+
+// dnsRoute 编译后的父域名到 DNS 服务商的映射
+type dnsRoute struct {
+	parent   *pattern
+	provider string
+}
+
+// dnsRouter 按父域名选择 DNS-01 验证使用的服务商,每个服务商拥有独立的证书申请客户端
+type dnsRouter struct {
+	routes   []dnsRoute
+	fallback string                          // 没有匹配的映射时使用的服务商,为空时报错
+	clients  map[string]*ssl.CertMagicClient // 按服务商名称(小写)保存
+}
+
+// newDNSRouter 根据配置创建所有服务商的客户端并编译映射规则,任何一项有误都会返回错误
+func newDNSRouter(conf config.SSLConf) (*dnsRouter, error) {
+	providers := conf.DNS.Providers
+	if len(providers) == 0 {
+		//兼容旧配置,只使用阿里云
+		providers = []config.DNSProviderConf{{
+			Name:            legacyDNSProvider,
+			Platform:        ssl.Aliyun,
+			AccessKeyID:     conf.Aliyun.AccessKeyID,
+			AccessKeySecret: conf.Aliyun.AccessKeySecret,
+		}}
+	}
+
+	r := &dnsRouter{clients: make(map[string]*ssl.CertMagicClient)}
+	issuers := acmeIssuers(conf.ACME)
+	for _, p := range providers {
+		name := strings.ToLower(p.Name)
+		if name == "" {
+			return nil, errors.New("DNS 服务商缺少名称")
+		}
+		if _, ok := r.clients[name]; ok {
+			return nil, fmt.Errorf("DNS 服务商 %s 重复声明", p.Name)
+		}
+
+		provider := ssl.NewProvider(p.Platform, p.AccessKeyID, p.AccessKeySecret, p.Token)
+		client, err := ssl.NewCertMagicClient(conf.Email, conf.SSLPath, provider, issuers...)
+		if err != nil {
+			return nil, fmt.Errorf("DNS 服务商 %s: %w", p.Name, err)
+		}
+		r.clients[name] = client
+	}
+
+	for i, z := range conf.DNS.Zones {
+		parent, err := compilePattern(z.Parent)
+		if err != nil || parent == nil {
+			return nil, fmt.Errorf("dns.zones[%d]: 父域名规则 %q 无效: %v", i, z.Parent, err)
+		}
+		if _, ok := r.clients[strings.ToLower(z.Provider)]; !ok {
+			return nil, fmt.Errorf("dns.zones[%d]: 未声明的 DNS 服务商 %s", i, z.Provider)
+		}
+		r.routes = append(r.routes, dnsRoute{parent: parent, provider: strings.ToLower(z.Provider)})
+	}
+
+	switch {
+	case conf.DNS.Default != "":
+		if _, ok := r.clients[strings.ToLower(conf.DNS.Default)]; !ok {
+			return nil, fmt.Errorf("dns.default: 未声明的 DNS 服务商 %s", conf.DNS.Default)
+		}
+		r.fallback = strings.ToLower(conf.DNS.Default)
+	case len(providers) == 1:
+		r.fallback = strings.ToLower(providers[0].Name)
+	}
+	return r, nil
+}
+
+// acmeIssuers 把配置转换为按顺序尝试的签发者列表,第一个为主签发者
+func acmeIssuers(conf config.ACMEConf) []ssl.Issuer {
+	issuers := make([]ssl.Issuer, 0, len(conf.Fallbacks)+1)
+	for _, c := range append([]config.ACMEIssuerConf{conf.ACMEIssuerConf}, conf.Fallbacks...) {
+		issuers = append(issuers, ssl.Issuer{
+			CA:           c.CA,
+			Staging:      c.Staging,
+			EABKeyID:     c.EAB.KeyID,
+			EABMACKey:    c.EAB.MACKey,
+			TrustedRoots: c.TrustedRoots,
+		})
+	}
+	return issuers
+}
+
+// provider 返回父域名使用的服务商名称,按配置顺序取第一个匹配的映射
+func (r *dnsRouter) provider(fatherDomain string) (string, error) {
+	if r == nil {
+		return "", errors.New("证书申请客户端尚未初始化,请检查配置")
+	}
+	for _, route := range r.routes {
+		if route.parent.match(fatherDomain) {
+			return route.provider, nil
+		}
+	}
+	if r.fallback == "" {
+		return "", fmt.Errorf("父域名 %s 没有匹配的 DNS 服务商", fatherDomain)
+	}
+	return r.fallback, nil
+}
+
+// client 返回为父域名申请证书使用的客户端
+func (r *dnsRouter) client(fatherDomain string) (*ssl.CertMagicClient, error) {
+	name, err := r.provider(fatherDomain)
+	if err != nil {
+		return nil, err
+	}
+	return r.clients[name], nil
+}
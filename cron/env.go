@@ -0,0 +1,72 @@
+package cron
+
+import (
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/email"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"log"
+	"sync"
+)
+
+// env 一份完整的客户端和配置。initConfig 每次都会生成新的 env 并整体替换,已经发布的 env 不会再被修改,
+// 任务开始时通过 currentEnv 取出一份,之后的责任链和轮询都使用这一份,执行期间不需要持有任何锁,
+// 配置的热更新只影响之后开始的任务
+type env struct {
+	qiniu    *qiniu.QiniuClient
+	ssl      *dao.SSLDao
+	runs     *dao.RunDao
+	cps      *dao.CheckpointDao
+	dns      *dnsRouter // 按父域名选择申请证书使用的 DNS 服务商
+	email    *email.EmailClient
+	receiver string
+
+	groupLimit int
+	retry      retryPolicy
+	renewal    config.RenewalConf
+	maxSANs    int // 单张证书最多包含的名称数量
+	https      config.HTTPSConf
+	key        config.KeyConf
+	cleanup    config.CleanupConf
+	filter     *domainFilter // 决定哪些域名由本服务管理
+	obtainSem  semaphore     // 限制同时进行的 ACME 申请
+	qiniuSem   semaphore     // 限制同时进行的七牛云写操作
+}
+
+var (
+	envMu sync.RWMutex // 只在读取和替换 cur 时持有
+	cur   = &env{
+		groupLimit: defaultGroupConcurrency,
+		retry:      newRetryPolicy(config.RetryConf{}),
+		obtainSem:  newSemaphore(defaultObtainConcurrency),
+		qiniuSem:   newSemaphore(defaultQiniuConcurrency),
+	}
+	strangerMap = NewStrategyMap()
+)
+
+// currentEnv 返回当前的客户端和配置
+func currentEnv() *env {
+	envMu.RLock()
+	defer envMu.RUnlock()
+	return cur
+}
+
+// setEnv 发布新的客户端和配置
+func setEnv(e *env) {
+	envMu.Lock()
+	defer envMu.Unlock()
+	cur = e
+}
+
+// alert 发送报警邮件,失败时只打印日志
+func (e *env) alert(text, html string) {
+	if e.email == nil {
+		log.Println("邮件客户端尚未初始化,无法发送报警邮件")
+		return
+	}
+	err := e.email.SendEmail([]string{e.receiver}, "七牛云自动报警服务", text, html, nil)
+	if err != nil {
+		// TODO 如果邮件也失败了的话应当输出到日志系统里
+		log.Println("发送报警邮件失败:", err)
+	}
+}
@@ -0,0 +1,157 @@
+package cron
+
+import (
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// regexPrefix 以该前缀开头的规则按正则表达式匹配,否则按 glob 匹配
+const regexPrefix = "re:"
+
+// pattern 编译后的单个匹配模式
+type pattern struct {
+	raw   string
+	regex *regexp.Regexp // 为 nil 时按 glob 匹配
+}
+
+// compilePattern 编译匹配模式,空字符串表示不限制
+func compilePattern(raw string) (*pattern, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	if expr, ok := strings.CutPrefix(raw, regexPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的正则表达式 %q: %w", raw, err)
+		}
+		return &pattern{raw: raw, regex: re}, nil
+	}
+	if _, err := path.Match(raw, ""); err != nil {
+		return nil, fmt.Errorf("无效的 glob %q: %w", raw, err)
+	}
+	return &pattern{raw: raw}, nil
+}
+
+// match 判断值是否符合模式,glob 匹配不区分大小写
+func (p *pattern) match(value string) bool {
+	if p == nil {
+		return true
+	}
+	if p.regex != nil {
+		return p.regex.MatchString(value)
+	}
+	ok, _ := path.Match(strings.ToLower(p.raw), strings.ToLower(value))
+	return ok
+}
+
+// filterRule 编译后的过滤规则,所有配置了的字段都匹配时规则才命中
+type filterRule struct {
+	desc     string
+	domain   *pattern
+	parent   *pattern
+	typ      *pattern
+	protocol *pattern
+	platform *pattern
+}
+
+// compileRule 编译单条过滤规则
+func compileRule(conf config.DomainRule) (*filterRule, error) {
+	r := &filterRule{}
+	fields := []struct {
+		name  string
+		value string
+		dst   **pattern
+	}{
+		{"domain", conf.Domain, &r.domain},
+		{"parent", conf.Parent, &r.parent},
+		{"type", conf.Type, &r.typ},
+		{"protocol", conf.Protocol, &r.protocol},
+		{"platform", conf.Platform, &r.platform},
+	}
+
+	var desc []string
+	for _, f := range fields {
+		p, err := compilePattern(f.value)
+		if err != nil {
+			return nil, err
+		}
+		*f.dst = p
+		if p != nil {
+			desc = append(desc, f.name+"="+f.value)
+		}
+	}
+	if len(desc) == 0 {
+		return nil, fmt.Errorf("过滤规则至少需要配置一个字段")
+	}
+	r.desc = strings.Join(desc, ",")
+	return r, nil
+}
+
+// match 判断域名是否命中规则
+func (r *filterRule) match(domain qiniu.Domain, parent string) bool {
+	return r.domain.match(domain.Name) &&
+		r.parent.match(parent) &&
+		r.typ.match(domain.Type) &&
+		r.protocol.match(domain.Protocol) &&
+		r.platform.match(domain.Platform)
+}
+
+// domainFilter 决定七牛云上的哪些域名由本服务管理
+type domainFilter struct {
+	include []*filterRule // 不为空时只管理命中任意一条规则的域名
+	exclude []*filterRule // 命中任意一条规则的域名不管理,优先于 include
+	err     error         // 规则编译失败时的错误,此时拒绝处理任何域名
+}
+
+// newDomainFilter 编译过滤配置,规则有误时返回的过滤器会拒绝所有域名
+func newDomainFilter(conf config.DomainFilterConf) *domainFilter {
+	f := &domainFilter{}
+	for i, c := range conf.Include {
+		r, err := compileRule(c)
+		if err != nil {
+			f.err = fmt.Errorf("include 第 %d 条规则有误: %w", i+1, err)
+			return f
+		}
+		f.include = append(f.include, r)
+	}
+	for i, c := range conf.Exclude {
+		r, err := compileRule(c)
+		if err != nil {
+			f.err = fmt.Errorf("exclude 第 %d 条规则有误: %w", i+1, err)
+			return f
+		}
+		f.exclude = append(f.exclude, r)
+	}
+	return f
+}
+
+// skipReason 返回域名不被管理的原因,返回空字符串表示需要管理
+func (f *domainFilter) skipReason(domain qiniu.Domain, parent string) string {
+	if f == nil {
+		return ""
+	}
+	for _, r := range f.exclude {
+		if r.match(domain, parent) {
+			return "命中 exclude 规则: " + r.desc
+		}
+	}
+	if len(f.include) == 0 {
+		return ""
+	}
+	for _, r := range f.include {
+		if r.match(domain, parent) {
+			return ""
+		}
+	}
+	return "未命中任何 include 规则"
+}
+
+// skipped 构造一条跳过记录
+func skipped(domain, reason string) dao.SkippedDomain {
+	return dao.SkippedDomain{Domain: domain, Reason: reason}
+}
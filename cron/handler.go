@@ -4,21 +4,9 @@ import (
 	"context"
 	"fmt"
 	"github.com/muxi-Infra/autossl-qiniuyun/dao"
-	"github.com/muxi-Infra/autossl-qiniuyun/pkg/email"
 	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
-	"github.com/muxi-Infra/autossl-qiniuyun/pkg/ssl"
 	"gorm.io/gorm"
-	"time"
-)
-
-var (
-	qiniuClient *qiniu.QiniuClient
-	sslDAO      *dao.SSLDao
-	cmClient    *ssl.CertMagicClient
-	emailClient *email.EmailClient
-	strangerMap = NewStrategyMap()
-	receiver    string
-	now         int64
+	"log"
 )
 
 const (
@@ -28,9 +16,29 @@ const (
 	UploadCertErrCode
 	ForceHTTPSErrCode
 	RemoveOldCertErrCode
-	StartAll = 0 //这里和第一个错误是一致的code
+	StartAll  = 0  //这里和第一个错误是一致的code
+	StageDone = -1 //责任链全部执行完毕
 )
 
+// stageNames 责任链各阶段的名称,用于执行记录
+var stageNames = map[int]string{
+	CheckLocalErrCode:     "checkLocalCert",
+	CheckQiniuCertErrCode: "checkQiniuCert",
+	ObtainCertErrCode:     "obtainCert",
+	UploadCertErrCode:     "uploadCert",
+	ForceHTTPSErrCode:     "forceHTTPS",
+	RemoveOldCertErrCode:  "removeOldCert",
+	StageDone:             "done",
+}
+
+// StageName 返回 code 对应的阶段名称
+func StageName(code int) string {
+	if name, ok := stageNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", code)
+}
+
 //1. 如果这个父域名证书找不到（本地和云端任何一个地方找不到）或者过期了就要申请并存储。保证存在可用的父域名证书
 
 // 2. 检查这个证书在本地是否已经存储了当前处理的域名,如果未存储则在七牛云上强制启用，并在本地进行存储
@@ -39,7 +47,9 @@ const (
 // 责任链处理器接口
 type Handler interface {
 	SetNext(handler Handler) Handler
-	Handle(ctx context.Context, domain *DomainWithCert) (code int, err error)
+	Handle(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error)
+	// Stage 返回处理器所在的阶段,与失败时返回的 code 一致
+	Stage() int
 }
 
 // 基础责任链结构体
@@ -57,12 +67,14 @@ func (h *BaseHandler) SetNext(handler Handler) Handler {
 	return handler
 }
 
-// 调用下一个处理器
-func (h *BaseHandler) HandleNext(ctx context.Context, domain *DomainWithCert) (code int, err error) {
+// 调用下一个处理器,调用前保存进度,全部完成后清除进度
+func (h *BaseHandler) HandleNext(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error) {
 	if h.next != nil {
-		return h.next.Handle(ctx, domain)
+		e.saveCheckpoint(domain, h.next.Stage())
+		return h.next.Handle(ctx, e, domain)
 	}
-	return -1, nil
+	e.clearCheckpoint(domain)
+	return StageDone, nil
 }
 
 // 1. 检查本地是否存在证书
@@ -71,18 +83,30 @@ type CheckLocalCertHandler struct {
 	BaseHandler
 }
 
-func (h *CheckLocalCertHandler) Handle(ctx context.Context, domain *DomainWithCert) (code int, err error) {
-	s, err := sslDAO.GetSSLByName(domain.FatherDomain)
+func (h *CheckLocalCertHandler) Stage() int {
+	return CheckLocalErrCode
+}
+
+func (h *CheckLocalCertHandler) Handle(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error) {
+	domain.CertId, err = e.lookupLocalCert(domain.CertName)
+	if err != nil {
+		return CheckLocalErrCode, err
+	}
+	return h.HandleNext(ctx, e, domain)
+}
+
+// lookupLocalCert 查询本地存储的分组证书 id,本地不存在时返回空字符串
+func (e *env) lookupLocalCert(certName string) (string, error) {
+	s, err := e.ssl.GetSSLByName(certName)
 	switch err {
 	case nil:
-		domain.CertId = s.CertID
+		return s.CertID, nil
 	case gorm.ErrRecordNotFound:
-		//本地不存在该父域名的证书,则不进行添加,下游逻辑会进行处理
-		domain.CertId = ""
+		//本地不存在该分组的证书,则不进行添加,下游逻辑会进行处理
+		return "", nil
 	default:
-		return CheckLocalErrCode, err
+		return "", err
 	}
-	return h.HandleNext(ctx, domain)
 }
 
 // 2. 检查云端仓库是否存在证书
@@ -90,27 +114,138 @@ type CheckQiniuCertHandler struct {
 	BaseHandler
 }
 
-func (h *CheckQiniuCertHandler) Handle(ctx context.Context, domain *DomainWithCert) (code int, err error) {
+func (h *CheckQiniuCertHandler) Stage() int {
+	return CheckQiniuCertErrCode
+}
+
+func (h *CheckQiniuCertHandler) Handle(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error) {
 	if domain.CertId != "" {
-		//如果id无法从七牛云上获取证书,说明证书不存在,将证书id设置为空
-		resp, err := qiniuClient.GETSSLCertById(domain.CertId)
+		state, err := e.inspectQiniuCert(ctx, domain.FatherDomain, domain.CertId, domain.Domains)
 		if err != nil {
+			return CheckQiniuCertErrCode, err
+		}
+		//强制续期时把仍然可用的证书当作即将过期处理
+		if domain.Force && state == qiniuCertValid {
+			state = qiniuCertExpiring
+		}
+		switch state {
+		case qiniuCertMissing:
 			//删除当前的本地证书,并将证书状态设置为无证书
-			err := sslDAO.DeleteSSL(domain.CertId)
+			err := e.ssl.DeleteSSL(domain.CertId)
 			if err != nil {
 				return CheckQiniuCertErrCode, err
 			}
 			domain.CertId = ""
-		} else {
-			//检查是否已经过期,如果过期需要删除当前的本地和云端的证书
-			if checkIfPass(resp.NotAfter) {
-				//设置证书为老证书,清除证书
-				domain.OldCertId = domain.CertId
-				domain.CertId = ""
-			}
+		case qiniuCertExpiring, qiniuCertUncovered:
+			//设置证书为老证书,清除证书,重新申请覆盖所有域名的证书
+			domain.OldCertId = domain.CertId
+			domain.CertId = ""
 		}
 	}
-	return h.HandleNext(ctx, domain)
+	return h.HandleNext(ctx, e, domain)
+}
+
+// 七牛云上证书的状态
+const (
+	qiniuCertValid     = iota // 证书存在且无需续期
+	qiniuCertMissing          // 证书在七牛云上不存在
+	qiniuCertExpiring         // 证书即将过期需要续期
+	qiniuCertUncovered        // 证书没有覆盖分组内的所有域名
+)
+
+// inspectQiniuCert 查询证书在七牛云上的状态以及是否覆盖 domains,不会做任何修改
+func (e *env) inspectQiniuCert(ctx context.Context, fatherDomain, certId string, domains []string) (int, error) {
+	resp, err := e.qiniu.GETSSLCertById(ctx, certId)
+	switch {
+	case qiniu.IsNotFound(err):
+		//只有七牛云明确返回证书不存在时才认为证书不存在,其他错误交给重试处理
+		return qiniuCertMissing, nil
+	case err != nil:
+		return qiniuCertValid, err
+	}
+
+	local, err := e.localCert(certId)
+	if err != nil {
+		return qiniuCertValid, err
+	}
+	info, err := certInfo(local, resp.Cert)
+	if err != nil {
+		return qiniuCertValid, fmt.Errorf("无法确定证书 %s 的有效期: %w", certId, err)
+	}
+
+	//按照证书本身的过期时间判断是否需要续期,需要时替换当前的本地和云端的证书
+	renew, err := e.needsRenewal(fatherDomain, info.NotBefore, info.NotAfter)
+	if err != nil {
+		return qiniuCertValid, fmt.Errorf("证书 %s: %w", certId, err)
+	}
+	if renew {
+		return qiniuCertExpiring, nil
+	}
+	if !coversAll(certSANs(fatherDomain, info.SANs), domains) {
+		return qiniuCertUncovered, nil
+	}
+	return qiniuCertValid, nil
+}
+
+// localCert 返回本地记录的证书,本地不存在时返回 nil
+func (e *env) localCert(certId string) (*dao.SSL, error) {
+	s, err := e.ssl.GetSSLByCertID(certId)
+	switch err {
+	case nil:
+		return s, nil
+	case gorm.ErrRecordNotFound:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// certInfo 返回证书的有效期和名称:优先使用本地记录中从证书解析出的信息,
+// 其次解析七牛云返回的证书内容,最后才使用七牛云返回的时间戳
+func certInfo(local *dao.SSL, remote qiniu.CertDetail) (*dao.CertInfo, error) {
+	if local != nil && !local.NotAfter.IsZero() {
+		return &dao.CertInfo{NotBefore: local.NotBefore, NotAfter: local.NotAfter, SANs: local.SANs}, nil
+	}
+	info, err := dao.ParseCertPEM(remote.Ca)
+	if err == nil {
+		return info, nil
+	}
+	if remote.NotAfter != 0 {
+		return &dao.CertInfo{NotBefore: unixTime(remote.NotBefore), NotAfter: unixTime(remote.NotAfter), SANs: remote.DNSNames}, nil
+	}
+	return nil, err
+}
+
+// certSANs 旧版本的记录没有解析出名称时,按旧版本只申请父域名通配符证书的行为处理
+func certSANs(fatherDomain string, sans []string) []string {
+	if len(sans) == 0 {
+		return []string{"*." + fatherDomain}
+	}
+	return sans
+}
+
+// localCertSANs 返回本地记录的证书名称
+func (e *env) localCertSANs(fatherDomain, certId string) ([]string, error) {
+	s, err := e.localCert(certId)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return certSANs(fatherDomain, nil), nil
+	}
+	return certSANs(fatherDomain, s.SANs), nil
+}
+
+// bindingCertSANs 返回即将绑定的证书包含的名称,新申请的证书尚未落库,直接从证书内容中解析
+func (e *env) bindingCertSANs(domain *DomainWithCert) ([]string, error) {
+	if domain.CertPEM == "" {
+		return e.localCertSANs(domain.FatherDomain, domain.CertId)
+	}
+	info, err := dao.ParseCertPEM(domain.CertPEM)
+	if err != nil {
+		return nil, err
+	}
+	return info.SANs, nil
 }
 
 // 3. 申请证书
@@ -118,11 +253,26 @@ type ObtainCertHandler struct {
 	BaseHandler
 }
 
-func (h *ObtainCertHandler) Handle(ctx context.Context, domain *DomainWithCert) (code int, err error) {
+func (h *ObtainCertHandler) Stage() int {
+	return ObtainCertErrCode
+}
+
+func (h *ObtainCertHandler) Handle(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error) {
 	//如果无证书
 	if domain.CertId == "" {
+		//按父域名选择托管该域名的 DNS 服务商完成 DNS-01 验证
+		client, err := e.dns.client(domain.FatherDomain)
+		if err != nil {
+			return ObtainCertErrCode, err
+		}
+
 		//尝试获取证书
-		certPEM, keyPEM, err := cmClient.ObtainCert(ctx, "*."+domain.FatherDomain)
+		if err := e.obtainSem.acquire(ctx); err != nil {
+			return ObtainCertErrCode, err
+		}
+		//按分组内实际的域名申请 apex 以及所需的各级通配符
+		certPEM, keyPEM, err := client.ObtainCert(ctx, sansFor(domain.FatherDomain, domain.Domains), e.keyOptions(domain.FatherDomain))
+		e.obtainSem.release()
 		if err != nil {
 			return ObtainCertErrCode, err
 		}
@@ -130,7 +280,7 @@ func (h *ObtainCertHandler) Handle(ctx context.Context, domain *DomainWithCert)
 		domain.KeyPEM = keyPEM
 	}
 
-	return h.HandleNext(ctx, domain)
+	return h.HandleNext(ctx, e, domain)
 }
 
 // 4. 上传证书
@@ -138,15 +288,27 @@ type UploadCertHandler struct {
 	BaseHandler
 }
 
-func (h *UploadCertHandler) Handle(ctx context.Context, domain *DomainWithCert) (code int, err error) {
+func (h *UploadCertHandler) Stage() int {
+	return UploadCertErrCode
+}
+
+func (h *UploadCertHandler) Handle(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error) {
+	//已有可用证书时没有新证书需要上传
+	if domain.CertPEM == "" {
+		return h.HandleNext(ctx, e, domain)
+	}
 
-	certId, err := qiniuClient.UPSSLCert(domain.KeyPEM, domain.CertPEM, domain.FatherDomain)
+	if err := e.qiniuSem.acquire(ctx); err != nil {
+		return UploadCertErrCode, err
+	}
+	certId, err := e.qiniu.UPSSLCert(ctx, domain.KeyPEM, domain.CertPEM, domain.CertName)
+	e.qiniuSem.release()
 	if err != nil {
 		return UploadCertErrCode, err
 	}
 
 	domain.CertId = certId.CertID
-	return h.HandleNext(ctx, domain)
+	return h.HandleNext(ctx, e, domain)
 }
 
 // 6. 强制开启 HTTPS并将成功的部分存到本地,失败的保留
@@ -154,36 +316,81 @@ type ForceHTTPSHandler struct {
 	BaseHandler
 }
 
-func (h *ForceHTTPSHandler) Handle(ctx context.Context, domain *DomainWithCert) (code int, err error) {
+func (h *ForceHTTPSHandler) Stage() int {
+	return ForceHTTPSErrCode
+}
+
+func (h *ForceHTTPSHandler) Handle(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error) {
 	//强制开启https并将失败的加入到失败列表里面
 	var fails []string
 	var success []string
-	for _, d := range domain.Domains {
-		//防止七牛云限流
-		time.Sleep(3 * time.Second)
+	var pending []string // 已经提交修改,等待生效的域名
+	sans, err := e.bindingCertSANs(domain)
+	if err != nil {
+		return ForceHTTPSErrCode, err
+	}
+	for i, d := range domain.Domains {
+		//退出的宽限期耗尽时不再处理剩余域名,已成功的部分照常落库,限流由 QiniuClient 内部处理
+		if ctx.Err() != nil {
+			fails = append(fails, domain.Domains[i:]...)
+			break
+		}
+		//不把不匹配的证书绑定到域名上
+		if !covers(sans, d) {
+			log.Printf("证书 %s 不包含域名 %s,跳过绑定\n", domain.CertId, d)
+			fails = append(fails, d)
+			continue
+		}
 
-		err = qiniuClient.ForceHTTPS(d, domain.CertId)
+		if e.qiniuSem.acquire(ctx) != nil {
+			fails = append(fails, domain.Domains[i:]...)
+			break
+		}
+		//按配置计算 https 选项,避免切换证书时关闭控制台上开启的强制跳转和 http2,
+		//已经绑定该证书的域名不会重复修改
+		changed, err := e.qiniu.BindCert(ctx, d, domain.CertId, e.httpsOptions(d, domain.FatherDomain))
+		e.qiniuSem.release()
 		if err != nil {
 			fails = append(fails, d)
 			continue
 		}
-		success = append(success, d)
+		if !changed {
+			success = append(success, d)
+			continue
+		}
+		pending = append(pending, d)
+	}
 
+	//等待修改生效并确认域名确实绑定了新证书,确认之后才记录为已绑定
+	for i, d := range pending {
+		if err := e.verifyBinding(ctx, d, domain.CertId); err != nil {
+			if ctx.Err() != nil {
+				fails = append(fails, pending[i:]...)
+				break
+			}
+			log.Printf("域名 %s 绑定证书 %s 未能确认生效: %v\n", d, domain.CertId, err)
+			fails = append(fails, d)
+			continue
+		}
+		success = append(success, d)
 	}
 
 	// 获取已存在的 SSL 证书
-	_, domains, err := sslDAO.GetDomains(domain.FatherDomain)
+	s, err := e.ssl.GetSSLByCertID(domain.CertId)
 	switch err {
 	case nil:
-		//如果能够找到证书不进行任何处理,因为需要再任务结束后再重新保存证书
-		// 更新证书与域名
-		err = sslDAO.UpdateSSL(domain.CertId, append(domains, success...))
+		// 证书已经在本地记录过,把新绑定的域名追加进去
+		var domains []string
+		for _, d := range s.Domains {
+			domains = append(domains, d.Name)
+		}
+		err = e.ssl.UpdateSSL(domain.CertId, append(domains, filterUnstoredDomains(success, domains)...))
 		if err != nil {
 			return ForceHTTPSErrCode, err
 		}
 	case gorm.ErrRecordNotFound:
-		// 如果查不到证书，创建新证书
-		err := sslDAO.CreateSSL(domain.CertId, domain.CertId, domain.KeyPEM, domain.Domains)
+		// 如果查不到证书，说明是本轮新申请的证书，创建新证书记录
+		err := e.ssl.CreateSSL(domain.CertName, domain.CertId, domain.CertPEM, domain.KeyPEM, success)
 		if err != nil {
 			return ForceHTTPSErrCode, err
 		}
@@ -192,10 +399,25 @@ func (h *ForceHTTPSHandler) Handle(ctx context.Context, domain *DomainWithCert)
 		return ForceHTTPSErrCode, fmt.Errorf("unexpected error: %w", err)
 	}
 
-	//将域名列表更新为失败域名
+	//将域名列表更新为失败域名,交给重试队列只重试这部分域名,旧证书要等全部域名换绑后才能删除
 	domain.Domains = fails
+	if len(fails) > 0 {
+		return ForceHTTPSErrCode, fmt.Errorf("域名 %v 绑定证书 %s 失败", fails, domain.CertId)
+	}
+
+	return h.HandleNext(ctx, e, domain)
+}
 
-	return h.HandleNext(ctx, domain)
+// verifyBinding 等待域名的修改生效,并确认域名当前绑定的是指定的证书
+func (e *env) verifyBinding(ctx context.Context, name, certId string) error {
+	detail, err := e.qiniu.WaitDomainReady(ctx, name)
+	if err != nil {
+		return err
+	}
+	if detail.HTTPS.CertID != certId {
+		return fmt.Errorf("域名当前绑定的证书为 %q", detail.HTTPS.CertID)
+	}
+	return nil
 }
 
 // 7. 移除远程久旧证书
@@ -203,18 +425,71 @@ type RemoveOldCertHandler struct {
 	BaseHandler
 }
 
-func (h *RemoveOldCertHandler) Handle(ctx context.Context, domain *DomainWithCert) (code int, err error) {
+func (h *RemoveOldCertHandler) Stage() int {
+	return RemoveOldCertErrCode
+}
+
+func (h *RemoveOldCertHandler) Handle(ctx context.Context, e *env, domain *DomainWithCert) (code int, err error) {
 	if domain.OldCertId != "" {
-		err := qiniuClient.RemoveSSLCert(domain.OldCertId)
+		//仍有域名绑定旧证书时不删除,等下一轮换绑完成后再处理
+		bound, err := e.stillBound(ctx, domain.OldCertId)
+		if err != nil {
+			return RemoveOldCertErrCode, err
+		}
+		if len(bound) > 0 {
+			log.Printf("域名 %v 仍绑定旧证书 %s,暂不删除\n", bound, domain.OldCertId)
+			return h.HandleNext(ctx, e, domain)
+		}
+
+		if err := e.qiniuSem.acquire(ctx); err != nil {
+			return RemoveOldCertErrCode, err
+		}
+		err = e.qiniu.RemoveSSLCert(ctx, domain.OldCertId)
+		e.qiniuSem.release()
 		if err != nil {
 			return RemoveOldCertErrCode, err
 		}
+
+		//同时删除本地的旧证书记录
+		err = e.ssl.DeleteSSL(domain.OldCertId)
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return RemoveOldCertErrCode, err
+		}
 	}
-	return h.HandleNext(ctx, domain)
+	return h.HandleNext(ctx, e, domain)
 }
 
-func StartStrategy(code int, domain *DomainWithCert) (int, error) {
-	return strangerMap[code].HandleNext(context.Background(), domain)
+// stillBound 返回本地记录中绑定旧证书、且在七牛云上仍然绑定该证书的域名
+func (e *env) stillBound(ctx context.Context, certId string) ([]string, error) {
+	s, err := e.localCert(certId)
+	if err != nil || s == nil {
+		return nil, err
+	}
+
+	var bound []string
+	for _, d := range s.Domains {
+		detail, err := e.qiniu.GetDomain(ctx, d.Name)
+		switch {
+		case qiniu.IsNotFound(err):
+			//域名已经从七牛云上删除
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("获取域名 %s 的详情失败: %w", d.Name, err)
+		}
+		if detail.HTTPS.CertID == certId {
+			bound = append(bound, d.Name)
+		}
+	}
+	return bound, nil
+}
+
+// StartStrategy 从 code 对应的阶段开始执行责任链,整个过程使用同一份 env
+func StartStrategy(ctx context.Context, e *env, code int, domain *DomainWithCert) (int, error) {
+	chain, ok := strangerMap[code]
+	if !ok {
+		return code, fmt.Errorf("unknown stage: %d", code)
+	}
+	return chain.HandleNext(ctx, e, domain)
 }
 
 func buildHandlerChain(handlers ...Handler) *BaseHandler {
@@ -274,10 +549,6 @@ func NewStrategyMap() map[int]*BaseHandler {
 	}
 }
 
-func checkIfPass(t int64) bool {
-	return now-t < ExpirationThreshold*SecondsPerDay
-}
-
 //	TODO 3.14计划
 //	1. 完成上传功能的重构
 //  2. 完成热更新功能的接口,目前打算直接提供一个GET和一个PUT接口实现最轻量化的更新
@@ -0,0 +1,216 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu/qiniutest"
+	"gorm.io/gorm"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestEnv 返回连接模拟服务的客户端和临时数据库组成的 env,同时替换责任链,测试结束后恢复
+func useTestEnv(t *testing.T, srv *qiniutest.Server) *env {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ssl.db")
+	s, err := dao.NewSSLDao(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := dao.NewRunDao(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := dao.NewCheckpointDao(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldStranger := strangerMap
+	t.Cleanup(func() { strangerMap = oldStranger })
+	//新证书由测试预先生成,这里去掉需要访问 ACME 的申请阶段
+	strangerMap = map[int]*BaseHandler{
+		CheckQiniuCertErrCode: buildHandlerChain(&CheckQiniuCertHandler{}, &UploadCertHandler{}, &ForceHTTPSHandler{}, &RemoveOldCertHandler{}),
+		UploadCertErrCode:     buildHandlerChain(&UploadCertHandler{}, &ForceHTTPSHandler{}, &RemoveOldCertHandler{}),
+		ForceHTTPSErrCode:     buildHandlerChain(&ForceHTTPSHandler{}, &RemoveOldCertHandler{}),
+		RemoveOldCertErrCode:  buildHandlerChain(&RemoveOldCertHandler{}),
+	}
+
+	return &env{
+		qiniu:      srv.Client(),
+		ssl:        s,
+		runs:       r,
+		cps:        c,
+		groupLimit: defaultGroupConcurrency,
+		retry: newRetryPolicy(config.RetryConf{
+			MaxAttempts: 3,
+			Backoff:     config.BackoffConf{Initial: time.Millisecond, Max: time.Millisecond},
+		}),
+		obtainSem: newSemaphore(defaultObtainConcurrency),
+		qiniuSem:  newSemaphore(defaultQiniuConcurrency),
+	}
+}
+
+// renewalItem 在模拟服务和本地数据库中准备父域名下绑定即将过期证书的域名,
+// 返回从检查七牛云证书阶段开始、已经带有新证书的执行项
+func renewalItem(t *testing.T, srv *qiniutest.Server, e *env, father string, domains ...string) *retryItem {
+	t.Helper()
+	//旧证书 10 天后过期,按默认的续期策略需要续期
+	now := time.Now()
+	oldPEM, oldKey, err := qiniutest.NewCertPEM([]string{"*." + father}, now.Add(-80*24*time.Hour), now.Add(10*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPEM, newKey, err := qiniutest.NewCertPEM([]string{"*." + father}, now, now.Add(90*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldCert, err := srv.AddCert(father, oldPEM, oldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range domains {
+		srv.AddDomain(qiniu.DomainDetail{
+			Domain: qiniu.Domain{Name: d, Protocol: "https"},
+			HTTPS:  qiniu.HTTPSConf{CertID: oldCert},
+		})
+	}
+	if err := e.ssl.CreateSSL(father, oldCert, oldPEM, oldKey, domains); err != nil {
+		t.Fatal(err)
+	}
+
+	return &retryItem{
+		domain: &DomainWithCert{
+			Domains:      domains,
+			FatherDomain: father,
+			CertName:     father,
+			CertId:       oldCert,
+			CertPEM:      newPEM,
+			KeyPEM:       newKey,
+		},
+		code: CheckQiniuCertErrCode,
+	}
+}
+
+func TestRenewChain(t *testing.T) {
+	const (
+		father   = "example.com"
+		oldCert  = "fakecert000001"
+		newCert  = "fakecert000002"
+		domainA  = "a.example.com"
+		domainB  = "b.example.com"
+		attempts = 2 // 注入的故障只生效一次,失败的分组在重试时成功
+	)
+
+	tests := []struct {
+		name   string
+		stage  int
+		method string
+		path   string
+	}{
+		{"checkQiniuCert", CheckQiniuCertErrCode, http.MethodGet, "/sslcert/" + oldCert},
+		{"uploadCert", UploadCertErrCode, http.MethodPost, "/sslcert"},
+		{"forceHTTPS", ForceHTTPSErrCode, http.MethodPut, "/domain/" + domainB + "/httpsconf"},
+		{"removeOldCert", RemoveOldCertErrCode, http.MethodDelete, "/sslcert/" + oldCert},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := qiniutest.NewServer("test-ak", "test-sk")
+			defer srv.Close()
+			e := useTestEnv(t, srv)
+
+			items := []*retryItem{renewalItem(t, srv, e, father, domainA, domainB)}
+			srv.FailNext(tt.method, tt.path, http.StatusInternalServerError, 1)
+
+			rec := newRunRecorder(nil, TriggerManual)
+			if errs := processGroups(context.Background(), context.Background(), e, items, rec); len(errs) > 0 {
+				t.Fatalf("unexpected errors: %v", errs[0].err)
+			}
+
+			item := items[0]
+			if item.attempts != attempts {
+				t.Errorf("got %d attempts, want %d", item.attempts, attempts)
+			}
+			if got := rec.groups[father]; got == nil || got.Stage != StageName(StageDone) {
+				t.Errorf("got run group %+v, want stage done", got)
+			}
+
+			//重试从失败的阶段继续,新证书只上传一次
+			if got := srv.Count(http.MethodPost, "/sslcert"); got != 1+boolInt(tt.stage == UploadCertErrCode) {
+				t.Errorf("got %d uploads", got)
+			}
+			for _, d := range []string{domainA, domainB} {
+				detail, _ := srv.Domain(d)
+				if detail.HTTPS.CertID != newCert {
+					t.Errorf("domain %s bound to %s, want %s", d, detail.HTTPS.CertID, newCert)
+				}
+			}
+			if _, ok := srv.Cert(oldCert); ok {
+				t.Errorf("old cert %s was not removed", oldCert)
+			}
+
+			//本地记录切换到新证书,旧证书的记录和处理进度都被清除
+			_, stored, err := e.ssl.GetDomains(father)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) != 2 {
+				t.Errorf("got stored domains %v, want both", stored)
+			}
+			if _, err := e.ssl.GetSSLByCertID(oldCert); err != gorm.ErrRecordNotFound {
+				t.Errorf("old local record: err=%v, want not found", err)
+			}
+			if cps, err := e.cps.ListCheckpoints(); err != nil || len(cps) != 0 {
+				t.Errorf("got checkpoints %v err=%v, want none", cps, err)
+			}
+		})
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestProcessGroupsOnSignal(t *testing.T) {
+	srv := qiniutest.NewServer("test-ak", "test-sk")
+	defer srv.Close()
+	e := useTestEnv(t, srv)
+	e.groupLimit = 1
+
+	started := renewalItem(t, srv, e, "example.com", "a.example.com", "b.example.com")
+	pending := renewalItem(t, srv, e, "example.org", "a.example.org")
+	//每个请求都需要一段时间,保证收到退出信号时第一个分组仍在执行
+	srv.SetLatency(50 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	rec := newRunRecorder(nil, TriggerManual)
+	errs := processGroups(ctx, workContext(ctx), e, []*retryItem{started, pending}, rec)
+
+	//已经开始的分组不会被退出信号中断,所有域名都换绑到新证书
+	if got := rec.groups["example.com"]; got == nil || got.Stage != StageName(StageDone) {
+		t.Errorf("got started group %+v, want stage done", got)
+	}
+	for _, d := range []string{"a.example.com", "b.example.com"} {
+		detail, _ := srv.Domain(d)
+		if detail.HTTPS.CertID != started.domain.CertId {
+			t.Errorf("domain %s bound to %s, want %s", d, detail.HTTPS.CertID, started.domain.CertId)
+		}
+	}
+
+	//尚未开始的分组不再派发,记录为未执行
+	if len(errs) != 1 || !errors.Is(errs[0].err, context.Canceled) {
+		t.Fatalf("got errors %v, want the pending group cancelled", errs)
+	}
+	if got := rec.groups["example.org"]; got == nil || got.Attempts != 0 {
+		t.Errorf("got pending group %+v, want not attempted", got)
+	}
+}
@@ -0,0 +1,57 @@
+package cron
+
+import (
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"strings"
+)
+
+const (
+	HTTPSModePreserve = "preserve" // 未指定的选项沿用域名当前的设置
+	HTTPSModeSet      = "set"      // 未指定的选项一律关闭
+)
+
+// httpsPolicy 返回域名使用的 https 选项,依次合并默认选项、父域名覆盖和域名覆盖
+func (e *env) httpsPolicy(domain, fatherDomain string) config.HTTPSPolicy {
+	policy := e.https.Default
+	//viper 会把 map 的 key 转为小写,这里忽略大小写匹配
+	for _, name := range []string{fatherDomain, domain} {
+		for key, override := range e.https.Overrides {
+			if strings.EqualFold(key, name) {
+				policy = mergeHTTPSPolicy(policy, override)
+			}
+		}
+	}
+	return policy
+}
+
+// mergeHTTPSPolicy 用 override 中填写了的字段覆盖 base
+func mergeHTTPSPolicy(base, override config.HTTPSPolicy) config.HTTPSPolicy {
+	if override.Mode != "" {
+		base.Mode = override.Mode
+	}
+	if override.ForceHttps != nil {
+		base.ForceHttps = override.ForceHttps
+	}
+	if override.Http2Enable != nil {
+		base.Http2Enable = override.Http2Enable
+	}
+	return base
+}
+
+// httpsOptions 计算为域名绑定证书时使用的 https 选项,
+// preserve 模式下未指定的选项保持为 nil,由 QiniuClient 沿用域名当前的设置
+func (e *env) httpsOptions(domain, fatherDomain string) qiniu.HTTPSOptions {
+	policy := e.httpsPolicy(domain, fatherDomain)
+	opts := qiniu.HTTPSOptions{ForceHttps: policy.ForceHttps, Http2Enable: policy.Http2Enable}
+	if strings.EqualFold(policy.Mode, HTTPSModeSet) {
+		disabled := false
+		if opts.ForceHttps == nil {
+			opts.ForceHttps = &disabled
+		}
+		if opts.Http2Enable == nil {
+			opts.Http2Enable = &disabled
+		}
+	}
+	return opts
+}
@@ -0,0 +1,48 @@
+package cron
+
+import (
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/ssl"
+	"strings"
+)
+
+// keyPolicy 返回父域名使用的私钥配置,父域名的覆盖配置优先
+func (e *env) keyPolicy(fatherDomain string) config.KeyPolicy {
+	policy := e.key.Default
+	//viper 会把 map 的 key 转为小写,这里忽略大小写匹配
+	for key, override := range e.key.Overrides {
+		if !strings.EqualFold(key, fatherDomain) {
+			continue
+		}
+		if override.Type != "" {
+			policy.Type = override.Type
+		}
+		if override.PKCS8 != nil {
+			policy.PKCS8 = override.PKCS8
+		}
+	}
+	return policy
+}
+
+// keyOptions 计算为父域名申请证书时使用的私钥选项
+func (e *env) keyOptions(fatherDomain string) ssl.KeyOptions {
+	policy := e.keyPolicy(fatherDomain)
+	return ssl.KeyOptions{
+		Type:  policy.Type,
+		PKCS8: policy.PKCS8 != nil && *policy.PKCS8,
+	}
+}
+
+// validateKeyConf 检查配置中的私钥类型是否都受支持
+func validateKeyConf(conf config.KeyConf) error {
+	if !ssl.ValidKeyType(conf.Default.Type) {
+		return fmt.Errorf("未知的私钥类型: %s", conf.Default.Type)
+	}
+	for name, policy := range conf.Overrides {
+		if !ssl.ValidKeyType(policy.Type) {
+			return fmt.Errorf("%s: 未知的私钥类型: %s", name, policy.Type)
+		}
+	}
+	return nil
+}
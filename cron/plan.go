@@ -0,0 +1,110 @@
+package cron
+
+import (
+	"context"
+	"errors"
+)
+
+// GroupPlan 单个证书分组在一轮任务中将要执行的操作
+type GroupPlan struct {
+	FatherDomain  string   `json:"fatherDomain"`  // 父域名
+	CertName      string   `json:"certName"`      // 证书名称,未拆分的分组与父域名相同
+	SANs          []string `json:"sans"`          // 覆盖本轮域名所需的证书名称
+	Domains       []string `json:"domains"`       // 本轮需要处理的域名
+	CurrentCertId string   `json:"currentCertId"` // 当前仍然可用的证书 id
+	ObtainCert    bool     `json:"obtainCert"`    // 是否会申请新证书
+	UploadCert    bool     `json:"uploadCert"`    // 是否会上传新证书到七牛云
+	SSLizeDomains []string `json:"sslizeDomains"` // 将要绑定证书并开启 https 的域名
+	RemoveCertId  string   `json:"removeCertId"`  // 将要从七牛云删除的旧证书 id
+	DNSProvider   string   `json:"dnsProvider"`   // 申请证书时 DNS-01 验证使用的服务商
+	Reason        string   `json:"reason"`        // 做出上述决定的原因
+	Error         string   `json:"error"`         // 检查过程中遇到的错误
+}
+
+// Plan 预演一轮任务,只读取本地与七牛云的状态,不会申请、上传、绑定或删除任何证书
+func (q *QiniuSSL) Plan(ctx context.Context) ([]GroupPlan, error) {
+	//命令行预演时定时任务没有启动,需要在这里初始化客户端,数据库只读打开,不会进行迁移
+	if currentEnv().qiniu == nil {
+		q.initPlanConfig()
+	}
+
+	e := currentEnv()
+	if e.qiniu == nil || e.ssl == nil {
+		return nil, errors.New("服务尚未初始化,请检查配置")
+	}
+
+	domainGroups, _, err := e.getDomainGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]GroupPlan, 0, len(domainGroups))
+	for _, g := range domainGroups {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		plans = append(plans, e.planGroup(ctx, g))
+	}
+	return plans, nil
+}
+
+// planGroup 按照 CheckLocalCertHandler 和 CheckQiniuCertHandler 的逻辑推演单个分组
+func (e *env) planGroup(ctx context.Context, g certGroup) GroupPlan {
+	plan := GroupPlan{
+		FatherDomain: g.FatherDomain,
+		CertName:     g.CertName,
+		Domains:      g.Domains,
+	}
+	if len(g.Domains) == 0 {
+		plan.Reason = "所有域名都已绑定可用证书,无需处理"
+		return plan
+	}
+	plan.SANs = sansFor(g.FatherDomain, g.Domains)
+
+	certId, err := e.lookupLocalCert(g.CertName)
+	if err != nil {
+		plan.Error = err.Error()
+		return plan
+	}
+
+	switch {
+	case certId == "":
+		plan.ObtainCert = true
+		plan.Reason = "本地没有该分组的证书"
+	default:
+		state, err := e.inspectQiniuCert(ctx, g.FatherDomain, certId, g.Domains)
+		if err != nil {
+			plan.Error = err.Error()
+			return plan
+		}
+		switch state {
+		case qiniuCertMissing:
+			plan.ObtainCert = true
+			plan.Reason = "本地证书在七牛云上不存在,将删除本地记录并重新申请"
+		case qiniuCertExpiring:
+			plan.ObtainCert = true
+			plan.RemoveCertId = certId
+			plan.Reason = "证书即将过期,需要续期"
+		case qiniuCertUncovered:
+			plan.ObtainCert = true
+			plan.RemoveCertId = certId
+			plan.Reason = "证书没有覆盖所有域名,需要重新申请"
+		default:
+			plan.CurrentCertId = certId
+			plan.Reason = "证书可用,只需要为新增的域名绑定证书"
+		}
+	}
+
+	if plan.ObtainCert {
+		provider, err := e.dns.provider(g.FatherDomain)
+		if err != nil {
+			plan.Error = err.Error()
+			return plan
+		}
+		plan.DNSProvider = provider
+	}
+
+	plan.UploadCert = plan.ObtainCert
+	plan.SSLizeDomains = g.Domains
+	return plan
+}
@@ -0,0 +1,68 @@
+package cron
+
+import (
+	"errors"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"strings"
+	"time"
+)
+
+// defaultRenewDaysBefore 未配置续期策略时,距离过期不足多少天续期
+const defaultRenewDaysBefore = 30
+
+// renewalPolicy 返回父域名使用的续期策略,存在覆盖配置时整体替换默认策略,不会逐个字段合并,
+// 这样短有效期证书的覆盖配置只填写 lifetimeFraction 时不会继承默认的 daysBefore
+func (e *env) renewalPolicy(fatherDomain string) config.RenewalPolicy {
+	//viper 会把 map 的 key 转为小写,这里忽略大小写匹配父域名
+	for name, policy := range e.renewal.Overrides {
+		if strings.EqualFold(name, fatherDomain) {
+			return policy
+		}
+	}
+	return e.renewal.Default
+}
+
+// renewAt 计算证书应当续期的时间点
+func renewAt(policy config.RenewalPolicy, notBefore, notAfter time.Time) time.Time {
+	daysBefore := policy.DaysBefore
+	//签发时间未知时无法按比例计算,此时只配置了比例的策略退回默认的天数
+	hasFraction := policy.LifetimeFraction > 0 && policy.LifetimeFraction < 1
+	canFraction := hasFraction && !notBefore.IsZero() && notBefore.Before(notAfter)
+	if daysBefore <= 0 && !canFraction {
+		daysBefore = defaultRenewDaysBefore
+	}
+
+	//两种方式都配置时取更早的时间点
+	at := notAfter
+	if daysBefore > 0 {
+		at = notAfter.Add(-time.Duration(daysBefore) * 24 * time.Hour)
+	}
+	if canFraction {
+		lifetime := notAfter.Sub(notBefore)
+		byFraction := notBefore.Add(time.Duration(float64(lifetime) * policy.LifetimeFraction))
+		if byFraction.Before(at) {
+			at = byFraction
+		}
+	}
+	return at
+}
+
+// errUnknownExpiry 证书的过期时间未知,无法判断是否需要续期
+var errUnknownExpiry = errors.New("证书的过期时间未知")
+
+// needsRenewal 统一的续期规则:到达父域名续期策略计算出的时间点后需要续期,
+// 过期时间未知(零值)时返回 errUnknownExpiry,由调用方决定如何处理,避免每轮都重新申请证书
+func (e *env) needsRenewal(fatherDomain string, notBefore, notAfter time.Time) (bool, error) {
+	if notAfter.IsZero() {
+		return false, errUnknownExpiry
+	}
+	return !time.Now().Before(renewAt(e.renewalPolicy(fatherDomain), notBefore, notAfter)), nil
+}
+
+// unixTime 将七牛云返回的秒级时间戳转换为 time.Time,0 表示未知,返回零值
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
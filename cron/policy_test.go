@@ -0,0 +1,37 @@
+package cron
+
+import (
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"testing"
+	"time"
+)
+
+func TestRenewAt(t *testing.T) {
+	day := 24 * time.Hour
+	notBefore := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter := notBefore.Add(90 * day)
+
+	tests := []struct {
+		name      string
+		policy    config.RenewalPolicy
+		notBefore time.Time
+		want      time.Time
+	}{
+		{"default", config.RenewalPolicy{}, notBefore, notAfter.Add(-30 * day)},
+		{"days only", config.RenewalPolicy{DaysBefore: 10}, notBefore, notAfter.Add(-10 * day)},
+		{"fraction only", config.RenewalPolicy{LifetimeFraction: 2.0 / 3}, notBefore, notBefore.Add(60 * day)},
+		{"both, fraction earlier", config.RenewalPolicy{DaysBefore: 10, LifetimeFraction: 0.5}, notBefore, notBefore.Add(45 * day)},
+		{"both, days earlier", config.RenewalPolicy{DaysBefore: 40, LifetimeFraction: 0.9}, notBefore, notAfter.Add(-40 * day)},
+		{"fraction out of range", config.RenewalPolicy{LifetimeFraction: 1.5}, notBefore, notAfter.Add(-30 * day)},
+		{"fraction only, unknown notBefore", config.RenewalPolicy{LifetimeFraction: 2.0 / 3}, time.Time{}, notAfter.Add(-30 * day)},
+		{"both, unknown notBefore", config.RenewalPolicy{DaysBefore: 10, LifetimeFraction: 0.5}, time.Time{}, notAfter.Add(-10 * day)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renewAt(tt.policy, tt.notBefore, notAfter)
+			if !got.Equal(tt.want) {
+				t.Fatalf("renewAt = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"gorm.io/gorm"
+)
+
+// ErrInvalidRenewal 手动续期请求的参数有误
+var ErrInvalidRenewal = errors.New("无效的续期请求")
+
+// RenewRequest 手动续期请求,FatherDomain 和 Domains 至少指定一个
+type RenewRequest struct {
+	FatherDomain string   // 父域名,只指定父域名时处理七牛云上该父域名下的所有域名
+	Domains      []string // 需要处理的域名,可以属于不同的父域名
+	Force        bool     // 忽略过期检查,强制申请新证书
+}
+
+// Renew 在后台立即对指定的域名执行责任链,返回可以轮询进度的任务 id(即执行记录 id)
+func (q *QiniuSSL) Renew(req RenewRequest) (uint, error) {
+	e := currentEnv()
+	if e.qiniu == nil || e.runs == nil {
+		return 0, errors.New("服务尚未初始化,请检查配置")
+	}
+
+	ctx, work := q.contexts()
+	groups, skipped, err := e.renewalGroups(ctx, req)
+	if err != nil {
+		return 0, err
+	}
+
+	rec := newRunRecorder(e.runs, TriggerManual)
+	if rec.runID == 0 {
+		return 0, errors.New("创建续期任务失败")
+	}
+	rec.skip(skipped)
+
+	var items []*retryItem
+	for _, g := range groups {
+		items = append(items, e.groupItem(g, req.Force))
+	}
+
+	q.renews.Add(1)
+	go func() {
+		defer q.renews.Done()
+
+		errs := processGroups(ctx, work, e, items, rec)
+		rec.finish(ctx.Err())
+
+		if len(errs) > 0 && ctx.Err() == nil {
+			e.alert("", q.generateErrorReportHTML(errs))
+		}
+	}()
+
+	return rec.runID, nil
+}
+
+// Wait 等待所有正在执行的手动续期任务结束。Start 收到的上下文取消后续期任务不再开始新的分组,
+// 已经开始的分组最多再执行 ShutdownGrace
+func (q *QiniuSSL) Wait() {
+	q.renews.Wait()
+}
+
+// contexts 返回 Start 收到的上下文和执行责任链使用的上下文,定时任务尚未启动时都返回 context.Background()
+func (q *QiniuSSL) contexts() (ctx, work context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.ctx == nil {
+		return context.Background(), context.Background()
+	}
+	return q.ctx, q.work
+}
+
+// renewalGroups 校验请求中的域名并按证书分组,非强制续期时去除已经绑定可用证书的域名,
+// 只指定父域名时同时返回该父域名下被过滤规则跳过的域名
+func (e *env) renewalGroups(ctx context.Context, req RenewRequest) ([]certGroup, []dao.SkippedDomain, error) {
+	all, allSkipped, err := e.listDomainGroups(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var skipped []dao.SkippedDomain
+	groups := make(map[string][]string)
+	switch {
+	case len(req.Domains) > 0:
+		known := make(map[string]struct{})
+		for _, domains := range all {
+			for _, d := range domains {
+				known[d] = struct{}{}
+			}
+		}
+
+		reasons := make(map[string]string)
+		for _, s := range allSkipped {
+			reasons[s.Domain] = s.Reason
+		}
+
+		seen := make(map[string]struct{})
+		for _, d := range req.Domains {
+			if reason, ok := reasons[d]; ok {
+				return nil, nil, fmt.Errorf("%w: 域名 %s 不由本服务管理(%s)", ErrInvalidRenewal, d, reason)
+			}
+			if _, ok := known[d]; !ok {
+				return nil, nil, fmt.Errorf("%w: 七牛云上不存在域名 %s", ErrInvalidRenewal, d)
+			}
+			parentDomain, err := getParentDomain(d)
+			if err != nil {
+				return nil, nil, fmt.Errorf("%w: 无法解析域名 %s", ErrInvalidRenewal, d)
+			}
+			if req.FatherDomain != "" && parentDomain != req.FatherDomain {
+				return nil, nil, fmt.Errorf("%w: 域名 %s 不属于 %s", ErrInvalidRenewal, d, req.FatherDomain)
+			}
+			if _, ok := seen[d]; ok {
+				continue
+			}
+			seen[d] = struct{}{}
+			groups[parentDomain] = append(groups[parentDomain], d)
+		}
+	case req.FatherDomain != "":
+		for _, s := range allSkipped {
+			if parentDomain, err := getParentDomain(s.Domain); err == nil && parentDomain == req.FatherDomain {
+				skipped = append(skipped, s)
+			}
+		}
+		domains, ok := all[req.FatherDomain]
+		if !ok {
+			return nil, nil, fmt.Errorf("%w: 七牛云上没有 %s 下的域名", ErrInvalidRenewal, req.FatherDomain)
+		}
+		groups[req.FatherDomain] = domains
+	default:
+		return nil, nil, fmt.Errorf("%w: 需要指定父域名或域名列表", ErrInvalidRenewal)
+	}
+
+	var certGroups []certGroup
+	for parentDomain, domains := range groups {
+		//按父域名下的全部域名规划证书,保证与定时任务拆分出的分组一致
+		requested := make(map[string]struct{}, len(domains))
+		for _, d := range domains {
+			requested[d] = struct{}{}
+		}
+		planned, err := e.planCertGroups(parentDomain, all[parentDomain])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, g := range planned {
+			var picked []string
+			for _, d := range g.Domains {
+				if _, ok := requested[d]; ok {
+					picked = append(picked, d)
+				}
+			}
+			if len(picked) == 0 {
+				continue
+			}
+			g.Domains = picked
+
+			if req.Force {
+				//强制续期会替换整张证书,已经绑定旧证书的域名也需要一起切换,否则旧证书无法删除
+				g.Domains, err = e.withStoredDomains(g)
+			} else {
+				g.Domains, err = e.pendingDomains(g)
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			certGroups = append(certGroups, g)
+		}
+	}
+	return certGroups, skipped, nil
+}
+
+// withStoredDomains 把本地记录中已经绑定分组证书的域名合并进来
+func (e *env) withStoredDomains(g certGroup) ([]string, error) {
+	_, storedDomains, err := e.ssl.GetDomains(g.CertName)
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		return g.Domains, nil
+	default:
+		return nil, err
+	}
+	return append(g.Domains, filterUnstoredDomains(storedDomains, g.Domains)...), nil
+}
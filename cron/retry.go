@@ -0,0 +1,236 @@
+package cron
+
+import (
+	"context"
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 未配置重试策略时使用的默认值
+const (
+	defaultMaxAttempts       = 3
+	defaultBackoffInitial    = 30 * time.Second
+	defaultBackoffMax        = 10 * time.Minute
+	defaultBackoffMultiplier = 2
+)
+
+// retryItem 等待重试的分组
+type retryItem struct {
+	domain   *DomainWithCert
+	code     int       // 下一次从责任链的哪个阶段开始
+	attempts int       // 已经执行的次数
+	due      time.Time // 下一次执行的时间
+	err      error     // 最近一次的错误
+}
+
+// retryQueue 按证书名称保存等待重试的分组,同一个分组只会保留一项
+type retryQueue struct {
+	mu    sync.Mutex
+	items map[string]*retryItem
+}
+
+func newRetryQueue() *retryQueue {
+	return &retryQueue{items: make(map[string]*retryItem)}
+}
+
+// push 加入或替换某个分组的重试项
+func (q *retryQueue) push(item *retryItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.items[item.domain.CertName] = item
+}
+
+// popDue 取出所有已经到期的重试项
+func (q *retryQueue) popDue(now time.Time) []*retryItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var due []*retryItem
+	for k, item := range q.items {
+		if !item.due.After(now) {
+			due = append(due, item)
+			delete(q.items, k)
+		}
+	}
+	return due
+}
+
+// drain 取出所有剩余的重试项
+func (q *retryQueue) drain() []*retryItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var items []*retryItem
+	for k, item := range q.items {
+		items = append(items, item)
+		delete(q.items, k)
+	}
+	return items
+}
+
+// nextDue 返回最早到期的时间,队列为空时第二个返回值为 false
+func (q *retryQueue) nextDue() (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var next time.Time
+	for _, item := range q.items {
+		if next.IsZero() || item.due.Before(next) {
+			next = item.due
+		}
+	}
+	return next, len(q.items) > 0
+}
+
+// retryPolicy 分组失败后的重试策略
+type retryPolicy struct {
+	maxAttempts int
+	backoff     config.BackoffConf
+	stages      map[string]config.BackoffConf
+}
+
+func newRetryPolicy(conf config.RetryConf) retryPolicy {
+	return retryPolicy{
+		maxAttempts: orDefault(conf.MaxAttempts, defaultMaxAttempts),
+		backoff:     withBackoffDefaults(conf.Backoff, config.BackoffConf{}),
+		stages:      conf.Stages,
+	}
+}
+
+// delay 计算某个阶段第 attempts 次失败后需要等待的时间
+func (p retryPolicy) delay(code, attempts int) time.Duration {
+	b := p.backoff
+	//viper 会把 map 的 key 转为小写,这里忽略大小写匹配阶段名
+	for name, stage := range p.stages {
+		if strings.EqualFold(name, StageName(code)) {
+			b = withBackoffDefaults(stage, p.backoff)
+			break
+		}
+	}
+
+	d := float64(b.Initial)
+	for i := 1; i < attempts; i++ {
+		d *= b.Multiplier
+		if d >= float64(b.Max) {
+			return b.Max
+		}
+	}
+	return time.Duration(d)
+}
+
+// withBackoffDefaults 使用 fallback 补全 b 中未配置的字段,fallback 也未配置时使用默认值
+func withBackoffDefaults(b, fallback config.BackoffConf) config.BackoffConf {
+	if b.Initial <= 0 {
+		b.Initial = fallback.Initial
+	}
+	if b.Initial <= 0 {
+		b.Initial = defaultBackoffInitial
+	}
+	if b.Max <= 0 {
+		b.Max = fallback.Max
+	}
+	if b.Max <= 0 {
+		b.Max = defaultBackoffMax
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = fallback.Multiplier
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = defaultBackoffMultiplier
+	}
+	return b
+}
+
+// processGroups 从各自的起始阶段并发执行所有分组,失败的分组进入重试队列,
+// 从失败的阶段继续执行,超过最大次数后视为失败并返回。
+// 包括重试在内的所有执行都使用同一份 env,执行期间更新配置不会影响本轮任务。
+// ctx 被取消后不再派发新的分组和重试,已经开始的分组使用 work 执行,不会因为 ctx 被取消而中断
+func processGroups(ctx, work context.Context, e *env, items []*retryItem, rec *runRecorder) []ErrWithDomain {
+	var mu sync.Mutex
+	var errs []ErrWithDomain
+	queue := newRetryQueue()
+
+	fail := func(item *retryItem) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, ErrWithDomain{
+			err:     item.err,
+			Domains: item.domain.Domains,
+		})
+	}
+
+	handle := func(item *retryItem) {
+		//定时任务和手动续期可能同时处理同一个分组,这里保证同一时间只有一个在执行
+		unlock := lockGroup(item.domain.CertName)
+		code, err := StartStrategy(work, e, item.code, item.domain)
+		unlock()
+		item.attempts++
+		rec.record(item.domain, code, err, item.attempts)
+		if err == nil {
+			return
+		}
+
+		item.code = code
+		item.err = err
+		if item.attempts >= e.retry.maxAttempts || ctx.Err() != nil {
+			fail(item)
+			return
+		}
+		item.due = time.Now().Add(e.retry.delay(code, item.attempts))
+		queue.push(item)
+	}
+
+	//收到退出信号后没有执行的分组记录为已取消
+	cancel := func(item *retryItem) {
+		item.err = fmt.Errorf("收到退出信号,分组未执行: %w", ctx.Err())
+		rec.record(item.domain, item.code, item.err, item.attempts)
+		fail(item)
+	}
+
+	for _, item := range items {
+		rec.begin(item.domain)
+	}
+
+	for len(items) > 0 {
+		dispatched := make([]bool, len(items))
+		forEachLimit(ctx, e.groupLimit, len(items), func(i int) {
+			dispatched[i] = true
+			handle(items[i])
+		})
+		for i, ok := range dispatched {
+			if !ok {
+				cancel(items[i])
+			}
+		}
+
+		next, ok := queue.nextDue()
+		if !ok {
+			break
+		}
+		//等待最早的重试到期,收到退出信号则放弃剩余的重试
+		if !waitUntil(ctx, next) {
+			break
+		}
+		items = queue.popDue(time.Now())
+	}
+
+	//只有收到退出信号时队列中才会有剩余的分组
+	for _, item := range queue.drain() {
+		cancel(item)
+	}
+	return errs
+}
+
+// groupLocks 每个分组(证书名称)一把锁
+var groupLocks sync.Map
+
+// lockGroup 锁定分组,返回解锁函数
+func lockGroup(certName string) func() {
+	v, _ := groupLocks.LoadOrStore(certName, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
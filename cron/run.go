@@ -0,0 +1,158 @@
+package cron
+
+import (
+	"errors"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"log"
+	"sync"
+)
+
+const (
+	TriggerSchedule = "schedule" // 定时调度触发
+	TriggerResume   = "resume"   // 启动时继续上次未完成的分组
+	TriggerManual   = "manual"   // 通过接口手动触发
+)
+
+// stagePending 分组尚未开始处理
+const stagePending = "pending"
+
+// runRecorder 收集一轮任务中各个父域名分组的结果并写入执行记录
+type runRecorder struct {
+	runs   *dao.RunDao
+	runID  uint
+	mu     sync.Mutex
+	order  []string                 // 分组的处理顺序
+	groups map[string]*dao.RunGroup // 按证书名称记录,重试的结果会覆盖之前的结果
+}
+
+// newRunRecorder 创建一条执行记录,记录失败时只打印日志,不影响续期流程
+func newRunRecorder(runs *dao.RunDao, trigger string) *runRecorder {
+	r := &runRecorder{runs: runs, groups: make(map[string]*dao.RunGroup)}
+	if r.runs == nil {
+		return r
+	}
+
+	run, err := r.runs.CreateRun(trigger)
+	if err != nil {
+		log.Println("创建执行记录失败:", err)
+		return r
+	}
+	r.runID = run.ID
+	return r
+}
+
+// begin 记录分组开始处理时的域名列表,立即写入以便轮询进度
+func (r *runRecorder) begin(d *DomainWithCert) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.groups[d.CertName]; ok {
+		return
+	}
+	g := &dao.RunGroup{
+		FatherDomain: d.FatherDomain,
+		CertName:     d.CertName,
+		Domains:      append([]string(nil), d.Domains...),
+		Stage:        stagePending,
+	}
+	r.order = append(r.order, d.CertName)
+	r.groups[d.CertName] = g
+	r.save(g)
+}
+
+// record 记录分组经过责任链之后的结果
+func (r *runRecorder) record(d *DomainWithCert, code int, err error, attempts int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, ok := r.groups[d.CertName]
+	if !ok {
+		return
+	}
+
+	g.Stage = StageName(code)
+	g.Code = code
+	g.Attempts = attempts
+	g.Error = ""
+	if err != nil {
+		g.Error = err.Error()
+	}
+	//成功时 Domains 中剩下的是开启 https 失败的域名,失败时则是尚未处理完的域名
+	g.FailedDomains = append([]string(nil), d.Domains...)
+	g.OldCertID = d.OldCertId
+	g.CertID = d.CertId
+	g.Issued = d.CertPEM != ""
+	r.save(g)
+}
+
+// save 写入或更新分组的结果,调用方需要持有 r.mu
+func (r *runRecorder) save(g *dao.RunGroup) {
+	if r.runs == nil || r.runID == 0 {
+		return
+	}
+
+	var err error
+	if g.ID == 0 {
+		err = r.runs.AddGroup(r.runID, g)
+	} else {
+		err = r.runs.UpdateGroup(g)
+	}
+	if err != nil {
+		log.Println("写入分组执行记录失败:", err)
+	}
+}
+
+// skip 记录本轮被跳过的域名
+func (r *runRecorder) skip(skipped []dao.SkippedDomain) {
+	if r.runs == nil || r.runID == 0 || len(skipped) == 0 {
+		return
+	}
+	if err := r.runs.SetSkipped(r.runID, skipped); err != nil {
+		log.Println("写入跳过的域名失败:", err)
+	}
+}
+
+// finish 写入所有分组的结果并结束执行记录
+func (r *runRecorder) finish(runErr error) {
+	if r.runs == nil || r.runID == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := dao.RunStatusSuccess
+	var errMsg string
+	if runErr != nil {
+		status = dao.RunStatusFailed
+		errMsg = runErr.Error()
+	}
+
+	for _, name := range r.order {
+		if r.groups[name].Error != "" {
+			status = dao.RunStatusFailed
+		}
+	}
+
+	if err := r.runs.FinishRun(r.runID, status, errMsg); err != nil {
+		log.Println("结束执行记录失败:", err)
+	}
+}
+
+// ListRuns 按时间倒序分页获取执行记录
+func (q *QiniuSSL) ListRuns(limit, offset int) ([]dao.Run, int64, error) {
+	e := currentEnv()
+	if e.runs == nil {
+		return nil, 0, errors.New("服务尚未初始化,请检查配置")
+	}
+	return e.runs.ListRuns(limit, offset)
+}
+
+// GetRun 获取单条执行记录
+func (q *QiniuSSL) GetRun(id uint) (*dao.Run, error) {
+	e := currentEnv()
+	if e.runs == nil {
+		return nil, errors.New("服务尚未初始化,请检查配置")
+	}
+	return e.runs.GetRun(id)
+}
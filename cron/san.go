@@ -0,0 +1,191 @@
+package cron
+
+import (
+	"gorm.io/gorm"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// defaultMaxSANs 未配置时单张证书最多包含的名称数量,与 Let's Encrypt 的限制一致
+const defaultMaxSANs = 100
+
+// certGroup 使用同一张证书的一组域名
+type certGroup struct {
+	FatherDomain string   // 父域名
+	CertName     string   // 证书名称,也是分组的唯一标识,未拆分的分组与父域名相同
+	Domains      []string // 分组内的域名
+}
+
+// sanFor 返回覆盖域名所需的证书名称:父域名本身需要 apex,其余域名使用上一级的通配符,
+// 七牛云的泛域名(如 .img.example.com)使用对应的通配符
+func sanFor(fatherDomain, domain string) string {
+	if strings.EqualFold(domain, fatherDomain) {
+		return fatherDomain
+	}
+	if strings.HasPrefix(domain, ".") {
+		return "*" + domain
+	}
+	_, rest, _ := strings.Cut(domain, ".")
+	return "*." + rest
+}
+
+// sansFor 返回覆盖所有域名需要的证书名称,去重并保持首次出现的顺序
+func sansFor(fatherDomain string, domains []string) []string {
+	var sans []string
+	seen := make(map[string]struct{})
+	for _, d := range sortDomains(domains) {
+		san := sanFor(fatherDomain, d)
+		if _, ok := seen[san]; ok {
+			continue
+		}
+		seen[san] = struct{}{}
+		sans = append(sans, san)
+	}
+	return sans
+}
+
+// covers 判断证书名称列表是否覆盖域名,通配符只匹配一级
+func covers(sans []string, domain string) bool {
+	for _, san := range sans {
+		if strings.EqualFold(san, domain) {
+			return true
+		}
+		base, ok := strings.CutPrefix(san, "*.")
+		if !ok {
+			continue
+		}
+		if strings.HasPrefix(domain, ".") {
+			if strings.EqualFold(domain[1:], base) {
+				return true
+			}
+			continue
+		}
+		if _, rest, ok := strings.Cut(domain, "."); ok && strings.EqualFold(rest, base) {
+			return true
+		}
+	}
+	return false
+}
+
+// coversAll 判断证书名称列表是否覆盖所有域名
+func coversAll(sans []string, domains []string) bool {
+	for _, d := range domains {
+		if !covers(sans, d) {
+			return false
+		}
+	}
+	return true
+}
+
+// planCertGroups 为父域名下的域名规划证书。父域名本身以及主证书当前已经包含的名称优先放进以父域名命名的主证书,
+// 其余名称按层级排序,没有拆分过且不超过上限时放进主证书;无法放入的名称按通配符拆分为独立的分组,
+// 证书以通配符的上一级命名,避免把不匹配的证书绑定到域名上。
+// 已经放进主证书或拆分出去的名称保持原来的分组,新增域名不会改变已有分组的证书名称
+func (e *env) planCertGroups(fatherDomain string, domains []string) ([]certGroup, error) {
+	limit := orDefault(e.maxSANs, defaultMaxSANs)
+	current, err := e.mainCertSANs(fatherDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := sortDomains(domains)
+	sans := sansFor(fatherDomain, sorted)
+	mainSANs := make(map[string]struct{})
+	addMain := func(san string) bool {
+		if _, ok := mainSANs[san]; !ok && len(mainSANs) >= limit {
+			return false
+		}
+		mainSANs[san] = struct{}{}
+		return true
+	}
+	for _, san := range sans {
+		if strings.EqualFold(san, fatherDomain) || slices.Contains(current, san) {
+			addMain(san)
+		}
+	}
+
+	var splits []string
+	for _, san := range sans {
+		if _, ok := mainSANs[san]; ok {
+			continue
+		}
+		split, err := e.hasCert(splitCertName(san))
+		if err != nil {
+			return nil, err
+		}
+		if split || !addMain(san) {
+			splits = append(splits, san)
+		}
+	}
+
+	main := certGroup{FatherDomain: fatherDomain, CertName: fatherDomain}
+	splitGroups := make(map[string]*certGroup)
+	for _, san := range splits {
+		splitGroups[san] = &certGroup{FatherDomain: fatherDomain, CertName: splitCertName(san)}
+	}
+	for _, d := range sorted {
+		san := sanFor(fatherDomain, d)
+		if g, ok := splitGroups[san]; ok {
+			g.Domains = append(g.Domains, d)
+		} else {
+			main.Domains = append(main.Domains, d)
+		}
+	}
+
+	groups := []certGroup{main}
+	for _, san := range splits {
+		groups = append(groups, *splitGroups[san])
+	}
+	return groups, nil
+}
+
+// splitCertName 拆分出的独立证书的名称,为通配符的上一级
+func splitCertName(san string) string {
+	return strings.TrimPrefix(san, "*.")
+}
+
+// mainCertSANs 返回本地记录的父域名主证书包含的名称,没有记录时返回 nil
+func (e *env) mainCertSANs(fatherDomain string) ([]string, error) {
+	if e.ssl == nil {
+		return nil, nil
+	}
+	s, err := e.ssl.GetSSLByName(fatherDomain)
+	switch err {
+	case nil:
+		return certSANs(fatherDomain, s.SANs), nil
+	case gorm.ErrRecordNotFound:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// hasCert 判断本地是否记录了指定名称的证书
+func (e *env) hasCert(certName string) (bool, error) {
+	if e.ssl == nil {
+		return false, nil
+	}
+	_, err := e.ssl.GetSSLByName(certName)
+	switch err {
+	case nil:
+		return true, nil
+	case gorm.ErrRecordNotFound:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// sortDomains 按层级从浅到深排序,保证每次规划的结果一致,且父域名和一级子域名优先放进主证书
+func sortDomains(domains []string) []string {
+	sorted := append([]string(nil), domains...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		li, lj := strings.Count(sorted[i], "."), strings.Count(sorted[j], ".")
+		if li != lj {
+			return li < lj
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
@@ -0,0 +1,74 @@
+package cron
+
+import (
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu/qiniutest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlanCertGroupsStable(t *testing.T) {
+	s, err := dao.NewSSLDao(filepath.Join(t.TempDir(), "ssl.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &env{ssl: s, maxSANs: 3}
+	const father = "example.com"
+
+	certNames := func(domains []string) map[string]string {
+		t.Helper()
+		groups, err := e.planCertGroups(father, domains)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make(map[string]string)
+		for _, g := range groups {
+			for _, d := range g.Domains {
+				names[d] = g.CertName
+			}
+		}
+		return names
+	}
+
+	domains := []string{"example.com", "a.example.com", "b.c.example.com", "d.e.f.example.com"}
+	before := certNames(domains)
+	want := map[string]string{
+		"example.com":       father,
+		"a.example.com":     father,
+		"b.c.example.com":   father,
+		"d.e.f.example.com": "e.f.example.com",
+	}
+	for d, name := range want {
+		if before[d] != name {
+			t.Fatalf("got %s in %s, want %s", d, before[d], name)
+		}
+	}
+
+	//按规划结果为每个分组签发证书并记录到本地
+	groups, err := e.planCertGroups(father, domains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, g := range groups {
+		certPEM, keyPEM, err := qiniutest.NewCertPEM(sansFor(father, g.Domains), time.Now(), time.Now().Add(90*24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.CreateSSL(g.CertName, fmt.Sprintf("cert%d", i), certPEM, keyPEM, g.Domains); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//新增的域名按层级排在 b.c.example.com 之前,但不会把已有的名称挤出主证书
+	after := certNames(append(domains, "a.b.example.com"))
+	for d, name := range before {
+		if after[d] != name {
+			t.Errorf("%s moved from %s to %s", d, name, after[d])
+		}
+	}
+	if got := after["a.b.example.com"]; got != "b.example.com" {
+		t.Errorf("got new domain in %s, want b.example.com", got)
+	}
+}
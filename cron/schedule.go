@@ -0,0 +1,75 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/config"
+	cronexpr "github.com/robfig/cron/v3"
+	"math/rand/v2"
+	"time"
+)
+
+// Scheduler 根据 cron 表达式计算下一次执行时间
+type Scheduler struct {
+	schedule cronexpr.Schedule
+	loc      *time.Location
+	jitter   time.Duration
+}
+
+// NewScheduler 根据配置创建调度器,未配置 cron 表达式时退化为按固定间隔执行
+func NewScheduler(conf config.ScheduleConf, interval time.Duration) (*Scheduler, error) {
+	loc := time.Local
+	if conf.TimeZone != "" {
+		var err error
+		loc, err = time.LoadLocation(conf.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("无法解析时区 %s: %w", conf.TimeZone, err)
+		}
+	}
+
+	spec := conf.Spec
+	if spec == "" {
+		if interval <= 0 {
+			return nil, errors.New("schedule.spec 和 duration 至少需要配置一个")
+		}
+		spec = "@every " + interval.String()
+	}
+
+	schedule, err := cronexpr.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("无法解析 cron 表达式 %s: %w", spec, err)
+	}
+
+	if conf.Jitter < 0 {
+		return nil, errors.New("schedule.jitter 不能为负数")
+	}
+
+	return &Scheduler{
+		schedule: schedule,
+		loc:      loc,
+		jitter:   conf.Jitter,
+	}, nil
+}
+
+// Next 返回 from 之后的下一次执行时间,已经加上随机抖动
+func (s *Scheduler) Next(from time.Time) time.Time {
+	next := s.schedule.Next(from.In(s.loc))
+	if s.jitter > 0 {
+		next = next.Add(rand.N(s.jitter))
+	}
+	return next
+}
+
+// waitUntil 阻塞到 t 或 ctx 被取消,ctx 被取消时返回 false
+func waitUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -1,165 +1,329 @@
 package cron
 
 import (
+	"context"
 	"fmt"
 	"github.com/muxi-Infra/autossl-qiniuyun/config"
 	"github.com/muxi-Infra/autossl-qiniuyun/dao"
 	"github.com/muxi-Infra/autossl-qiniuyun/pkg/email"
 	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
-	"github.com/muxi-Infra/autossl-qiniuyun/pkg/ssl"
 	"golang.org/x/net/publicsuffix"
 	"gorm.io/gorm"
+	"log"
+	"net/url"
+	"sync"
 	"time"
 )
 
+// scheduleRetryInterval 调度配置有误时重新读取配置的间隔
+const scheduleRetryInterval = time.Minute
+
+// ShutdownGrace 收到退出信号后,已经开始的分组最多还能继续执行的时间,超过后才取消正在进行的调用
+const ShutdownGrace = 25 * time.Second
+
+// workContext 返回执行责任链使用的上下文:ctx(退出信号)被取消时不会立即取消,
+// 而是再等待 ShutdownGrace,让已经开始的分组有机会完成,退出信号本身只用于停止派发新的分组和重试
+func workContext(ctx context.Context) context.Context {
+	work, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	context.AfterFunc(ctx, func() {
+		time.AfterFunc(ShutdownGrace, cancel)
+	})
+	return work
+}
+
 type QiniuSSL struct {
+	mu      sync.Mutex
+	nextRun time.Time       // 下一次计划执行的时间
+	ctx     context.Context // Start 收到的上下文,取消后手动续期任务不再派发新的分组
+	work    context.Context // 执行责任链使用的上下文,由 ctx 派生,见 workContext
+	renews  sync.WaitGroup  // 正在执行的手动续期任务
 }
 
 func NewQiniuSSL() *QiniuSSL {
 	return &QiniuSSL{}
 }
 
-func (q *QiniuSSL) Start() {
-	//首次启动进行的操作
+func (q *QiniuSSL) Start(ctx context.Context) {
+	work := workContext(ctx)
+	q.mu.Lock()
+	q.ctx, q.work = ctx, work
+	q.mu.Unlock()
 
-	//强制为所有的域名申请证书
-	for {
+	//首次启动进行的操作,提前初始化客户端,保证等待期间接口可用
+	first := true
+	q.initConfig()
 
-		//初始化配置
-		q.initConfig()
+	//继续处理上次进程退出时尚未完成的分组,避免重复申请和上传证书
+	q.resume(ctx, work)
 
-		//按照父域名对域名进行分组
-		domainGroups, err := q.getDomainGroups()
+	//强制为所有的域名申请证书
+	for {
+		//每一轮都重新读取调度配置,保证热更新后下一轮生效
+		cron := config.GetCronConfig()
+		scheduler, err := NewScheduler(cron.Schedule, cron.Duration)
 		if err != nil {
-			//发送邮件
-			err := emailClient.SendEmail([]string{receiver}, "七牛云自动报警服务", fmt.Sprintf("域名列表分组失败!:%s", err.Error()), "", nil)
-			if err != nil {
+			log.Println("调度配置有误:", err)
+			if !waitUntil(ctx, time.Now().Add(scheduleRetryInterval)) {
 				return
 			}
 			continue
 		}
 
-		//存储到失败的map里面
-		var failMap = make(map[int]*DomainWithCert)
-		for k, v := range domainGroups {
-			var d = DomainWithCert{
-				Domains:      v,
-				FatherDomain: k,
-			}
-			code, err := StartStrategy(StartAll, &d)
-			if err != nil {
-				failMap[code] = &d
-			}
+		next := scheduler.Next(time.Now())
+		if first && cron.Schedule.RunOnStart {
+			next = time.Now()
 		}
+		first = false
+		q.setNextRun(next)
 
-		var errs []ErrWithDomain
+		//等待到计划时间,期间收到退出信号则直接返回
+		if !waitUntil(ctx, next) {
+			return
+		}
 
-		//遍历failMap
-		for k, v := range failMap {
-			_, err := StartStrategy(k, v)
-			if err != nil {
-				errs = append(errs, ErrWithDomain{
-					err:     err,
-					Domains: v.Domains,
-				})
-			}
+		//初始化配置
+		q.initConfig()
+
+		q.runOnce(ctx, work)
 
+		if ctx.Err() != nil {
+			return
 		}
+	}
+}
 
-		//如果有错误则收集并发送最终报文
+// NextRun 返回下一次计划执行的时间
+func (q *QiniuSSL) NextRun() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.nextRun
+}
 
-		if len(errs) > 0 {
-			//发送邮件
-			err := emailClient.SendEmail([]string{receiver}, "七牛云自动报警服务", "", q.generateErrorReportHTML(errs), nil)
-			if err != nil {
-				// TODO 如果邮件也失败了的话应当输出到日志系统里
-			}
-		}
+func (q *QiniuSSL) setNextRun(t time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextRun = t
+}
+
+// runOnce 执行一轮完整的证书检查与续期,ctx 被取消后不再开始新的分组,已经开始的分组使用 work 继续执行
+func (q *QiniuSSL) runOnce(ctx, work context.Context) {
+	e := currentEnv()
+	rec := newRunRecorder(e.runs, TriggerSchedule)
+
+	//按照父域名对域名进行分组
+	domainGroups, skipped, err := e.getDomainGroups(ctx)
+	rec.skip(skipped)
+	if err != nil {
+		rec.finish(err)
+		//发送邮件
+		e.alert(fmt.Sprintf("域名列表分组失败!:%s", err.Error()), "")
+		return
+	}
+
+	var items []*retryItem
+	for _, g := range domainGroups {
+		items = append(items, e.groupItem(g, false))
+	}
+
+	errs := processGroups(ctx, work, e, items, rec)
+	if ctx.Err() != nil {
+		rec.finish(ctx.Err())
+		return
+	}
+
+	rec.finish(nil)
+
+	//清理过期以及没有绑定任何域名的证书
+	e.scheduledCleanup(work)
 
+	//如果有错误则收集并发送最终报文
+
+	if len(errs) > 0 {
+		//发送邮件
+		e.alert("", q.generateErrorReportHTML(errs))
 	}
 }
 
 func (q *QiniuSSL) initConfig() {
+	q.loadConfig(openDAOs)
+}
+
+// initPlanConfig 预演时的初始化,数据库以只读方式打开,不会迁移表结构或补全数据
+func (q *QiniuSSL) initPlanConfig() {
+	q.loadConfig(openReadOnlyDAOs)
+}
+
+// loadMu 保证同一时间只有一次配置加载
+var loadMu sync.Mutex
+
+// loadConfig 读取配置,在当前 env 的基础上生成新的 env 并发布,openDB 负责打开数据库并设置新 env 的 DAO。
+// 正在执行的任务继续使用之前的 env,不需要等待它们结束
+func (q *QiniuSSL) loadConfig(openDB func(e *env, path string) error) {
+	loadMu.Lock()
+	defer loadMu.Unlock()
+
+	next := *currentEnv()
+	defer func() { setEnv(&next) }()
 
 	//获取所有相关配置
 	cron := config.GetCronConfig()
 
-	//停止一段时间防止被识别为攻击
-	time.Sleep(cron.Duration)
-
 	//当出现更改时才进行修改
 	if cron.QiniuConf.Changed {
-		qiniuClient = qiniu.NewQiniuClient(cron.AccessKey, cron.SecretKey)
+		next.qiniu = qiniu.NewQiniuClient(cron.AccessKey, cron.SecretKey, qiniuOptions(cron.QiniuConf)...)
 	}
 
 	if cron.EmailConf.Changed {
-		emailClient = email.NewEmailClient(cron.UserName, cron.Password, cron.Sender, cron.SmtpHost, cron.SmtpPort)
+		next.email = email.NewEmailClient(cron.UserName, cron.Password, cron.Sender, cron.SmtpHost, cron.SmtpPort)
 	}
 
 	if cron.SSLConf.Changed {
-		var err error
-		sslDAO, err = dao.NewSSLDao(cron.DB)
+		//先应用互不依赖的配置,数据库或证书申请客户端初始化失败时不影响其他配置生效
+		next.receiver = cron.Receiver
+
+		next.groupLimit = orDefault(cron.Concurrency.Groups, defaultGroupConcurrency)
+		next.obtainSem = newSemaphore(orDefault(cron.Concurrency.Obtain, defaultObtainConcurrency))
+		next.qiniuSem = newSemaphore(orDefault(cron.Concurrency.Qiniu, defaultQiniuConcurrency))
+		next.retry = newRetryPolicy(cron.Retry)
+		next.renewal = cron.Renewal
+		next.maxSANs = cron.MaxSANs
+		next.https = cron.HTTPS
+		next.key = cron.Key
+		if err := validateKeyConf(next.key); err != nil {
+			log.Println("私钥配置有误,申请证书时会失败:", err)
+		}
+		next.cleanup = cron.Cleanup
+		next.filter = newDomainFilter(cron.Domains)
+		if next.filter.err != nil {
+			log.Println("域名过滤规则有误,在修正之前不会处理任何域名:", next.filter.err)
+		}
+
+		if err := openDB(&next, cron.DB); err != nil {
+			log.Println("打开数据库失败,继续使用之前的数据库:", err)
+		}
+
+		router, err := newDNSRouter(cron.SSLConf)
 		if err != nil {
-			// TODO
-			return
+			log.Println("初始化证书申请客户端失败,继续使用之前的配置:", err)
+		} else {
+			next.dns = router
 		}
+	}
+}
+
+// openDAOs 打开数据库,全部成功后才替换 e 中的 DAO
+func openDAOs(e *env, path string) error {
+	s, err := dao.NewSSLDao(path)
+	if err != nil {
+		return err
+	}
+	r, err := dao.NewRunDao(path)
+	if err != nil {
+		return err
+	}
+	c, err := dao.NewCheckpointDao(path)
+	if err != nil {
+		return err
+	}
+	e.ssl, e.runs, e.cps = s, r, c
+	return nil
+}
+
+// openReadOnlyDAOs 以只读方式打开预演需要的数据库,预演不会写入执行记录和处理进度
+func openReadOnlyDAOs(e *env, path string) error {
+	s, err := dao.NewReadOnlySSLDao(path)
+	if err != nil {
+		return err
+	}
+	e.ssl = s
+	return nil
+}
 
-		provider := ssl.NewProvider(ssl.Aliyun, cron.Aliyun.AccessKeyID, cron.Aliyun.AccessKeySecret, "")
+// getDomainGroups 获取所有需要管理的域名，按父域名分组后规划证书,同时返回被跳过的域名及原因
+func (e *env) getDomainGroups(ctx context.Context) ([]certGroup, []dao.SkippedDomain, error) {
+	domainGroups, skipped, err := e.listDomainGroups(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		cmClient, err = ssl.NewCertMagicClient(cron.Email, cron.SSLPath, provider)
+	var groups []certGroup
+	for parentDomain, domains := range domainGroups {
+		planned, err := e.planCertGroups(parentDomain, domains)
 		if err != nil {
-			// TODO
-			return
+			return nil, skipped, err
+		}
+		for _, g := range planned {
+			// 从需要处理的表格中删除所有已经在符合条件的证书下的域名
+			g.Domains, err = e.pendingDomains(g)
+			if err != nil {
+				return nil, skipped, err
+			}
+			groups = append(groups, g)
 		}
-		receiver = cron.Receiver
 	}
-	now = time.Now().Unix()
 
+	return groups, skipped, nil
 }
 
-const (
-	ExpirationThreshold = 30 // 证书过期阈值（天）
-	SecondsPerDay       = 24 * 60 * 60
-)
+// listDomainGroups 获取七牛云上的所有域名，按过滤规则去除不需要管理的域名后按父域名分组
+func (e *env) listDomainGroups(ctx context.Context) (map[string][]string, []dao.SkippedDomain, error) {
+	if e.filter != nil && e.filter.err != nil {
+		return nil, nil, fmt.Errorf("域名过滤规则有误: %w", e.filter.err)
+	}
 
-// getDomainGroups 获取所有域名，并按父域名分组
-func (q *QiniuSSL) getDomainGroups() (map[string][]string, error) {
 	domainGroups := make(map[string][]string)
-	domainList, err := qiniuClient.GetDomainList()
+	domainList, err := e.qiniu.GetDomainList(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get domain list: %w", err)
+		return nil, nil, fmt.Errorf("failed to get domain list: %w", err)
 	}
 
 	// 按父域名分组
+	var skippedDomains []dao.SkippedDomain
 	for _, domain := range domainList.Domains {
 		parentDomain, err := getParentDomain(domain.Name)
 		if err != nil {
-			fmt.Printf("无法解析域名 %s: %v\n", domain.Name, err)
+			skippedDomains = append(skippedDomains, skipped(domain.Name, "无法解析父域名: "+err.Error()))
+			continue
+		}
+		if reason := e.filter.skipReason(domain, parentDomain); reason != "" {
+			skippedDomains = append(skippedDomains, skipped(domain.Name, reason))
 			continue
 		}
 		domainGroups[parentDomain] = append(domainGroups[parentDomain], domain.Name)
 	}
 
-	// 从需要处理的表格中删除所有已经在符合条件的证书下的域名
-	for parentDomain, domains := range domainGroups {
-		// 获取已存储的域名及证书过期时间
-		certTime, storedDomains, err := sslDAO.GetDomains(parentDomain)
-		switch err {
-		case nil:
-		case gorm.ErrRecordNotFound:
-			continue
-		default:
-			return nil, err
-		}
+	return domainGroups, skippedDomains, nil
+}
 
-		now := time.Now().Unix()
-		// 如果证书未过期，则去除已存储的域名
-		if now-certTime < ExpirationThreshold*SecondsPerDay {
-			domainGroups[parentDomain] = filterUnstoredDomains(domains, storedDomains)
-		}
+// pendingDomains 如果分组的证书未过期，则去除已经绑定该证书的域名;
+// 剩下的域名不在证书范围内时需要重新申请证书,此时分组内的所有域名都需要换绑到新证书上
+func (e *env) pendingDomains(g certGroup) ([]string, error) {
+	// 获取已存储的域名及证书过期时间
+	info, storedDomains, err := e.ssl.GetDomains(g.CertName)
+	switch err {
+	case nil:
+	case gorm.ErrRecordNotFound:
+		return g.Domains, nil
+	default:
+		return nil, err
 	}
 
-	return domainGroups, nil
+	renew, err := e.needsRenewal(g.FatherDomain, info.NotBefore, info.NotAfter)
+	if err != nil {
+		//本地记录缺少过期时间时交给责任链,由检查七牛云证书的阶段根据证书本身判断
+		log.Printf("分组 %s 的本地证书记录缺少过期时间,将检查七牛云上的证书: %v", g.CertName, err)
+		return g.Domains, nil
+	}
+	if renew {
+		return g.Domains, nil
+	}
+
+	// 如果证书无需续期，则去除已存储的域名
+	pending := filterUnstoredDomains(g.Domains, storedDomains)
+	if len(info.SANs) > 0 && !coversAll(info.SANs, pending) {
+		return g.Domains, nil
+	}
+	return pending, nil
 }
 
 // filterUnstoredDomains 过滤掉已经存储的域名
@@ -240,8 +404,37 @@ type ErrWithDomain struct {
 type DomainWithCert struct {
 	Domains      []string //域名列表
 	FatherDomain string   //父域名
+	CertName     string   //证书名称,也是分组的唯一标识,未拆分的分组与父域名相同
 	OldCertId    string   //旧证书的id
 	CertId       string   //证书id
 	CertPEM      string   //证书的内容
 	KeyPEM       string   //证书的内容
+	Force        bool     //忽略过期检查,强制申请新证书
+}
+
+// qiniuOptions 根据配置生成七牛云客户端的选项
+func qiniuOptions(conf config.QiniuConf) []qiniu.Option {
+	limit := conf.RateLimit
+	opts := []qiniu.Option{
+		qiniu.WithBaseURL(conf.BaseURL),
+		qiniu.WithTimeout(conf.Timeout),
+		qiniu.WithUserAgent(conf.UserAgent),
+		qiniu.WithPollInterval(conf.PollInterval),
+		qiniu.WithOperationTimeout(conf.OperationTimeout),
+		qiniu.WithRateLimit(qiniu.FamilyDomain, qiniu.RateLimit{QPS: limit.Domain.QPS, Burst: limit.Domain.Burst}),
+		qiniu.WithRateLimit(qiniu.FamilySSLCert, qiniu.RateLimit{QPS: limit.SSLCert.QPS, Burst: limit.SSLCert.Burst}),
+		qiniu.WithRateLimit(qiniu.FamilySSLize, qiniu.RateLimit{QPS: limit.SSLize.QPS, Burst: limit.SSLize.Burst}),
+	}
+	if conf.Proxy != "" {
+		proxy, err := url.Parse(conf.Proxy)
+		if err != nil {
+			log.Println("七牛云代理地址无效,将不使用代理:", err)
+		} else {
+			opts = append(opts, qiniu.WithProxy(proxy))
+		}
+	}
+	if limit.MaxRetries != nil {
+		opts = append(opts, qiniu.WithMaxRetries(*limit.MaxRetries))
+	}
+	return opts
 }
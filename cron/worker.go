@@ -0,0 +1,64 @@
+package cron
+
+import (
+	"context"
+	"sync"
+)
+
+// 未配置并发数时使用的默认值
+const (
+	defaultGroupConcurrency  = 4 // 同时处理的父域名分组数量
+	defaultObtainConcurrency = 1 // 同时向 ACME 申请证书的数量
+	defaultQiniuConcurrency  = 2 // 同时对七牛云发起写操作的数量
+)
+
+// semaphore 限制同一阶段同时进行的操作数量
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		n = 1
+	}
+	return make(semaphore, n)
+}
+
+// acquire 获取一个名额,ctx 被取消时返回错误
+func (s semaphore) acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release 归还一个名额
+func (s semaphore) release() {
+	<-s
+}
+
+// forEachLimit 最多同时运行 limit 个 fn,ctx 被取消后不再派发新的任务,等待已开始的任务结束后返回
+func forEachLimit(ctx context.Context, limit, n int, fn func(i int)) {
+	sem := newSemaphore(limit)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		if sem.acquire(ctx) != nil {
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer sem.release()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// orDefault 配置值小于等于 0 时使用默认值
+func orDefault(n, def int) int {
+	if n <= 0 {
+		return def
+	}
+	return n
+}
@@ -0,0 +1,72 @@
+package dao
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// CertInfo 从证书内容中解析出的信息
+type CertInfo struct {
+	NotBefore time.Time
+	NotAfter  time.Time
+	Issuer    string
+	Serial    string
+	SANs      []string
+}
+
+// ParseCertPEM 解析 PEM 中的第一张证书(叶子证书)
+func ParseCertPEM(certPEM string) (*CertInfo, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("证书内容不是有效的 PEM 格式")
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析证书失败: %w", err)
+	}
+
+	return &CertInfo{
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Issuer:    cert.Issuer.String(),
+		Serial:    fmt.Sprintf("%x", cert.SerialNumber),
+		SANs:      cert.DNSNames,
+	}, nil
+}
+
+// apply 把证书信息写入 SSL 记录
+func (info *CertInfo) apply(ssl *SSL) {
+	ssl.NotBefore = info.NotBefore
+	ssl.NotAfter = info.NotAfter
+	ssl.Issuer = info.Issuer
+	ssl.Serial = info.Serial
+	ssl.SANs = info.SANs
+}
+
+// backfillCertInfo 为缺少有效期的记录从 CertPEM 中补全证书信息,
+// 无法解析的记录保持零值,续期判断时会根据七牛云上的证书本身确定有效期
+func backfillCertInfo(db *gorm.DB) {
+	var ssls []SSL
+	if err := db.Where("not_after IS NULL OR not_after = ?", time.Time{}).Find(&ssls).Error; err != nil {
+		log.Println("查询待补全的证书记录失败:", err)
+		return
+	}
+
+	for _, ssl := range ssls {
+		info, err := ParseCertPEM(ssl.CertPEM)
+		if err != nil {
+			log.Printf("证书 %s 无法解析,将在下一轮重新申请: %v\n", ssl.CertID, err)
+			continue
+		}
+		info.apply(&ssl)
+		if err := db.Model(&ssl).Select("NotBefore", "NotAfter", "Issuer", "Serial", "SANs").Updates(&ssl).Error; err != nil {
+			log.Printf("补全证书 %s 的信息失败: %v\n", ssl.CertID, err)
+		}
+	}
+}
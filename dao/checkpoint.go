@@ -0,0 +1,57 @@
+package dao
+
+import (
+	"gorm.io/gorm"
+)
+
+// CheckpointDao 负责分组处理进度的数据库操作
+type CheckpointDao struct {
+	db *gorm.DB
+}
+
+// NewCheckpointDao 创建一个新的 CheckpointDao 实例
+func NewCheckpointDao(path string) (*CheckpointDao, error) {
+	db, err := openDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckpointDao{db: db}, nil
+}
+
+// SaveCheckpoint 保存分组的处理进度,同一个证书名称已存在时覆盖
+func (dao *CheckpointDao) SaveCheckpoint(cp *Checkpoint) error {
+	return dao.db.Transaction(func(tx *gorm.DB) error {
+		// 每个分组只保留一条进度,这里连同软删除的记录一起物理删除
+		if err := tx.Unscoped().Where("cert_name = ?", cp.CertName).Delete(&Checkpoint{}).Error; err != nil {
+			return err
+		}
+		cp.ID = 0
+		return tx.Create(cp).Error
+	})
+}
+
+// DeleteCheckpoint 删除分组的处理进度
+func (dao *CheckpointDao) DeleteCheckpoint(certName string) error {
+	return dao.db.Unscoped().Where("cert_name = ?", certName).Delete(&Checkpoint{}).Error
+}
+
+// GetCheckpoint 获取分组的处理进度
+func (dao *CheckpointDao) GetCheckpoint(certName string) (*Checkpoint, error) {
+	var cp Checkpoint
+	err := dao.db.Where("cert_name = ?", certName).First(&cp).Error
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// ListCheckpoints 获取所有未完成的处理进度
+func (dao *CheckpointDao) ListCheckpoints() ([]Checkpoint, error) {
+	var cps []Checkpoint
+	err := dao.db.Order("id").Find(&cps).Error
+	if err != nil {
+		return nil, err
+	}
+	return cps, nil
+}
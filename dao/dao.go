@@ -4,8 +4,62 @@ import (
 	"fmt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"os"
+	"sync"
 )
 
+var (
+	dbs   = make(map[string]*gorm.DB) // 按路径缓存已经打开的数据库,避免多个 Dao 各自持有连接
+	dbsMu sync.Mutex
+)
+
+// openDB 打开 sqlite 数据库并迁移所有表结构,同一路径只会打开一次
+func openDB(path string) (*gorm.DB, error) {
+	dbsMu.Lock()
+	defer dbsMu.Unlock()
+
+	if db, ok := dbs[path]; ok {
+		return db, nil
+	}
+
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %w", err)
+	}
+
+	// sqlite 同一时间只允许一个写入者,并发处理分组时统一排队使用一个连接
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database: %w", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	// 自动迁移表结构
+	if err := db.AutoMigrate(&SSL{}, &Domain{}, &Run{}, &RunGroup{}, &Checkpoint{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+
+	// 为旧版本写入的证书记录补全有效期等信息
+	backfillCertInfo(db)
+
+	dbs[path] = db
+	return db, nil
+}
+
+// openReadOnlyDB 以只读方式打开已有的 sqlite 数据库,不迁移表结构也不补全数据,
+// 用于只读取状态的预演,不会放入缓存,数据库不存在时返回错误而不是创建
+func openReadOnlyDB(path string) (*gorm.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect database: %w", err)
+	}
+	return db, nil
+}
+
 // SSLDao 负责 SSL 表的数据库操作
 type SSLDao struct {
 	db *gorm.DB
@@ -13,28 +67,48 @@ type SSLDao struct {
 
 // NewSSLDao 创建一个新的 SSLDao 实例
 func NewSSLDao(path string) (*SSLDao, error) {
-	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	db, err := openDB(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect database: %w", err)
+		return nil, err
 	}
 
-	// 自动迁移表结构
-	if err := db.AutoMigrate(&SSL{}, &Domain{}); err != nil {
-		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	return &SSLDao{db: db}, nil
+}
+
+// NewReadOnlySSLDao 以只读方式创建 SSLDao,不会迁移表结构或修改任何记录
+func NewReadOnlySSLDao(path string) (*SSLDao, error) {
+	db, err := openReadOnlyDB(path)
+	if err != nil {
+		return nil, err
 	}
 
 	return &SSLDao{db: db}, nil
 }
 
-// CreateSSL 创建 SSL 证书记录
-func (dao *SSLDao) CreateSSL(certID, certPEM, keyPEM string, domains []string) error {
-	ssl := SSL{CertID: certID, CertPEM: certPEM, KeyPEM: keyPEM}
+// CreateSSL 创建 SSL 证书记录,证书的有效期等信息从 certPEM 中解析,
+// domains 中已经绑定在其他证书下的域名会被转移到新证书下
+func (dao *SSLDao) CreateSSL(domainName, certID, certPEM, keyPEM string, domains []string) error {
+	info, err := ParseCertPEM(certPEM)
+	if err != nil {
+		return err
+	}
+
+	ssl := SSL{DomainName: domainName, CertID: certID, CertPEM: certPEM, KeyPEM: keyPEM}
+	info.apply(&ssl)
 	// 将域名转换为 Domain 结构体
 	for _, domain := range domains {
 		ssl.Domains = append(ssl.Domains, Domain{Name: domain})
 	}
 
-	return dao.db.Create(&ssl).Error
+	return dao.db.Transaction(func(tx *gorm.DB) error {
+		if len(domains) > 0 {
+			// 域名是唯一索引,这里需要连同软删除的记录一起物理删除
+			if err := tx.Unscoped().Where("name IN ?", domains).Delete(&Domain{}).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Create(&ssl).Error
+	})
 }
 
 // GetSSLByID 通过 certId 获取 SSL 证书
@@ -50,7 +124,8 @@ func (dao *SSLDao) GetSSLByID(certId string) (*SSL, error) {
 // GetSSLByID 通过 certId 获取 SSL 证书
 func (dao *SSLDao) GetSSLByName(name string) (*SSL, error) {
 	var ssl SSL
-	err := dao.db.Preload("Domains").Where("domain_name= ?", name).First(&ssl).Error
+	// 同一个父域名可能存在新旧两张证书,优先返回最新的
+	err := dao.db.Preload("Domains").Where("domain_name= ?", name).Order("id desc").First(&ssl).Error
 	if err != nil {
 		return nil, err
 	}
@@ -77,13 +152,14 @@ func (dao *SSLDao) GetSSLByCertID(certID string) (*SSL, error) {
 	return &ssl, nil
 }
 
-func (dao *SSLDao) GetDomains(domainName string) (int64, []string, error) {
-	var ssl SSL
+// GetDomains 获取父域名最新证书的信息及其绑定的域名
+func (dao *SSLDao) GetDomains(domainName string) (*CertInfo, []string, error) {
 	var domainNames []string
 
 	// 查询 SSL 记录
-	if err := dao.db.Preload("Domains").Where("domain_name = ?", domainName).First(&ssl).Error; err != nil {
-		return 0, nil, err
+	ssl, err := dao.GetSSLByName(domainName)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// 提取所有域名
@@ -91,7 +167,13 @@ func (dao *SSLDao) GetDomains(domainName string) (int64, []string, error) {
 		domainNames = append(domainNames, domain.Name)
 	}
 
-	return ssl.CreatedAt.Unix(), domainNames, nil
+	return &CertInfo{
+		NotBefore: ssl.NotBefore,
+		NotAfter:  ssl.NotAfter,
+		Issuer:    ssl.Issuer,
+		Serial:    ssl.Serial,
+		SANs:      ssl.SANs,
+	}, domainNames, nil
 }
 
 // UpdateSSL 更新 SSL 证书的域名
@@ -113,11 +195,18 @@ func (dao *SSLDao) UpdateSSL(certID string, newDomains []string) error {
 		return err
 	}
 
-	// 删除旧的域名记录
-	err = tx.Where("ssl_id = ?", ssl.ID).Delete(&Domain{}).Error
+	// 删除旧的域名记录,以及绑定在其他证书下的同名域名
+	// 域名是唯一索引,这里需要连同软删除的记录一起物理删除
+	err = tx.Unscoped().Where("ssl_id = ?", ssl.ID).Delete(&Domain{}).Error
 	if err != nil {
 		return err
 	}
+	if len(newDomains) > 0 {
+		err = tx.Unscoped().Where("name IN ?", newDomains).Delete(&Domain{}).Error
+		if err != nil {
+			return err
+		}
+	}
 
 	// 添加新的域名记录
 	for _, domain := range newDomains {
@@ -138,10 +227,10 @@ func (dao *SSLDao) DeleteSSL(certID string) error {
 	}
 
 	// 删除关联的域名
-	if err := dao.db.Where("ssl_id = ?", ssl.ID).Delete(&Domain{}).Error; err != nil {
+	if err := dao.db.Unscoped().Where("ssl_id = ?", ssl.ID).Delete(&Domain{}).Error; err != nil {
 		return err
 	}
 
-	// 删除 SSL 记录
-	return dao.db.Delete(&ssl).Error
+	// 删除 SSL 记录,CertID 是唯一索引,这里同样物理删除
+	return dao.db.Unscoped().Delete(&ssl).Error
 }
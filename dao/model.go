@@ -2,6 +2,7 @@ package dao
 
 import (
 	"gorm.io/gorm"
+	"time"
 )
 
 // SSL 证书表
@@ -11,7 +12,12 @@ type SSL struct {
 	CertID     string `gorm:"unique;not null"` // 证书 ID
 	CertPEM    string
 	KeyPEM     string
-	Domains    []Domain `gorm:"foreignKey:SSLID"` // 关联 Domain
+	NotBefore  time.Time // 证书生效时间
+	NotAfter   time.Time // 证书过期时间
+	Issuer     string    // 签发者
+	Serial     string    // 序列号(十六进制)
+	SANs       []string  `gorm:"serializer:json"`  // 证书包含的域名
+	Domains    []Domain  `gorm:"foreignKey:SSLID"` // 关联 Domain
 }
 
 // Domain 域名表
@@ -20,3 +26,52 @@ type Domain struct {
 	Name  string `gorm:"unique;not null"` // 域名
 	SSLID uint   // 关联的 SSL 证书 ID
 }
+
+// Run 每一轮续期任务的执行记录
+type Run struct {
+	gorm.Model
+	Trigger   string          // 触发方式
+	Status    string          // 执行状态
+	StartedAt time.Time       // 开始时间
+	EndedAt   *time.Time      // 结束时间,未结束时为空
+	Error     string          // 整轮失败时的错误信息
+	Skipped   []SkippedDomain `gorm:"serializer:json"`  // 被过滤规则跳过的域名
+	Groups    []RunGroup      `gorm:"foreignKey:RunID"` // 关联每个父域名分组的结果
+}
+
+// SkippedDomain 本轮没有处理的域名及原因
+type SkippedDomain struct {
+	Domain string `json:"domain"`
+	Reason string `json:"reason"`
+}
+
+// RunGroup 一轮任务中单个父域名分组的处理结果
+type RunGroup struct {
+	gorm.Model
+	RunID         uint     `gorm:"index"`
+	FatherDomain  string   // 父域名
+	CertName      string   // 证书名称,未拆分的分组与父域名相同
+	Domains       []string `gorm:"serializer:json"` // 本轮需要处理的域名
+	FailedDomains []string `gorm:"serializer:json"` // 处理失败的域名
+	Stage         string   // 责任链到达的阶段
+	Code          int      // 责任链返回的 code
+	Attempts      int      // 执行的次数,包含重试
+	Error         string   // 责任链返回的错误
+	OldCertID     string   // 被替换的旧证书 id
+	CertID        string   // 最终绑定的证书 id
+	Issued        bool     // 本轮是否申请并上传了新证书
+}
+
+// Checkpoint 分组在责任链中的处理进度,每经过一个处理器保存一次,用于进程重启后继续处理
+type Checkpoint struct {
+	gorm.Model
+	FatherDomain string   `gorm:"not null"`             // 父域名
+	CertName     string   `gorm:"uniqueIndex;not null"` // 证书名称,即分组的唯一标识,未拆分的分组与父域名相同
+	Stage        int      // 下一个要执行的阶段
+	Domains      []string `gorm:"serializer:json"` // 尚未处理完的域名
+	OldCertID    string   // 旧证书 id
+	CertID       string   // 证书 id
+	CertPEM      string   // 新申请的证书内容
+	KeyPEM       string   // 新申请的私钥内容
+	Force        bool     // 是否强制续期
+}
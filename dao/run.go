@@ -0,0 +1,101 @@
+package dao
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+const (
+	RunStatusRunning = "running"
+	RunStatusSuccess = "success"
+	RunStatusFailed  = "failed"
+)
+
+// RunDao 负责续期执行记录的数据库操作
+type RunDao struct {
+	db *gorm.DB
+}
+
+// NewRunDao 创建一个新的 RunDao 实例
+func NewRunDao(path string) (*RunDao, error) {
+	db, err := openDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RunDao{db: db}, nil
+}
+
+// CreateRun 创建一条执行中的记录
+func (dao *RunDao) CreateRun(trigger string) (*Run, error) {
+	run := Run{
+		Trigger:   trigger,
+		Status:    RunStatusRunning,
+		StartedAt: time.Now(),
+	}
+	if err := dao.db.Create(&run).Error; err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// AddGroup 记录某个父域名分组的处理结果
+func (dao *RunDao) AddGroup(runID uint, group *RunGroup) error {
+	group.RunID = runID
+	return dao.db.Create(group).Error
+}
+
+// UpdateGroup 更新某个父域名分组的处理结果
+func (dao *RunDao) UpdateGroup(group *RunGroup) error {
+	return dao.db.Save(group).Error
+}
+
+// SetSkipped 记录本轮被跳过的域名
+func (dao *RunDao) SetSkipped(runID uint, skipped []SkippedDomain) error {
+	return dao.db.Model(&Run{Model: gorm.Model{ID: runID}}).Select("Skipped").Updates(&Run{Skipped: skipped}).Error
+}
+
+// FinishRun 结束一条执行记录
+func (dao *RunDao) FinishRun(runID uint, status, errMsg string) error {
+	return dao.db.Model(&Run{}).Where("id = ?", runID).Updates(map[string]any{
+		"status":   status,
+		"ended_at": time.Now(),
+		"error":    errMsg,
+	}).Error
+}
+
+// ListRuns 按时间倒序分页获取执行记录
+func (dao *RunDao) ListRuns(limit, offset int) ([]Run, int64, error) {
+	var runs []Run
+	var total int64
+
+	if err := dao.db.Model(&Run{}).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := dao.db.Preload("Groups").Order("id desc").Limit(limit).Offset(offset).Find(&runs).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return runs, total, nil
+}
+
+// ListCertIDs 获取执行记录中出现过的所有证书 id
+func (dao *RunDao) ListCertIDs() ([]string, error) {
+	var ids []string
+	err := dao.db.Model(&RunGroup{}).Where("cert_id <> ''").Distinct().Pluck("cert_id", &ids).Error
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetRun 通过 id 获取执行记录
+func (dao *RunDao) GetRun(id uint) (*Run, error) {
+	var run Run
+	err := dao.db.Preload("Groups").Where("id = ?", id).First(&run).Error
+	if err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
@@ -15,6 +15,63 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/cleanup": {
+            "post": {
+                "description": "删除七牛云上已过期或由本工具上传、且没有绑定任何域名的证书(开启 onlyOwn 时只删除本工具上传的证书),默认只预演,dryRun 为 false 时才会真正删除",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "续期管理"
+                ],
+                "summary": "清理无用证书",
+                "parameters": [
+                    {
+                        "description": "清理请求",
+                        "name": "request",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/request.CleanupReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "清理成功",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Resp"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/response.CleanupResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "请求格式错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    },
+                    "500": {
+                        "description": "服务器错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    }
+                }
+            }
+        },
         "/config/yaml": {
             "get": {
                 "description": "返回整个 YAML 配置文件内容",
@@ -99,9 +156,337 @@ const docTemplate = `{
                     }
                 }
             }
+        },
+        "/plan": {
+            "get": {
+                "description": "按证书分组返回本轮将要申请、上传、绑定和删除的证书,不会做任何修改",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "续期管理"
+                ],
+                "summary": "预演续期任务",
+                "responses": {
+                    "200": {
+                        "description": "预演成功",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Resp"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/response.PlanResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "500": {
+                        "description": "服务器错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    }
+                }
+            }
+        },
+        "/renewals": {
+            "post": {
+                "description": "对指定的父域名或域名列表立即执行续期流程,返回可以轮询进度的任务 id",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "续期管理"
+                ],
+                "summary": "立即续期",
+                "parameters": [
+                    {
+                        "description": "续期请求",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/request.RenewalReq"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "任务已创建",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Resp"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/response.RenewalResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "请求格式错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    },
+                    "500": {
+                        "description": "服务器错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    }
+                }
+            }
+        },
+        "/renewals/{id}": {
+            "get": {
+                "description": "返回手动续期任务中每个分组的处理结果,只能查询通过 /renewals 创建的任务",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "续期管理"
+                ],
+                "summary": "获取手动续期任务进度",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "任务 id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "获取成功",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Resp"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/response.RunResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "请求格式错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    },
+                    "404": {
+                        "description": "任务不存在",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    },
+                    "500": {
+                        "description": "服务器错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    }
+                }
+            }
+        },
+        "/runs": {
+            "get": {
+                "description": "按时间倒序返回每一轮续期任务的执行记录",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "执行记录"
+                ],
+                "summary": "获取执行记录列表",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "每页数量,默认 20,最大 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "偏移量",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "获取成功",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Resp"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/response.ListRunsResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "请求格式错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    },
+                    "500": {
+                        "description": "服务器错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    }
+                }
+            }
+        },
+        "/runs/{id}": {
+            "get": {
+                "description": "返回某一轮续期任务中每个父域名分组的处理结果",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "执行记录"
+                ],
+                "summary": "获取执行记录详情",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "执行记录 id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "获取成功",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Resp"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/response.RunResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    },
+                    "400": {
+                        "description": "请求格式错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    },
+                    "404": {
+                        "description": "执行记录不存在",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    },
+                    "500": {
+                        "description": "服务器错误",
+                        "schema": {
+                            "$ref": "#/definitions/response.Resp"
+                        }
+                    }
+                }
+            }
+        },
+        "/schedule": {
+            "get": {
+                "description": "返回当前的 cron 调度配置以及下一次计划执行的时间",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "调度管理"
+                ],
+                "summary": "获取调度信息",
+                "responses": {
+                    "200": {
+                        "description": "获取成功",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/response.Resp"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/response.GetScheduleResp"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
         }
     },
     "definitions": {
+        "request.CleanupReq": {
+            "type": "object",
+            "properties": {
+                "dryRun": {
+                    "description": "只返回将要删除的证书,不会真正删除,默认为 true",
+                    "type": "boolean"
+                }
+            }
+        },
         "request.PUTConfReq": {
             "type": "object",
             "properties": {
@@ -111,6 +496,75 @@ const docTemplate = `{
                 }
             }
         },
+        "request.RenewalReq": {
+            "type": "object",
+            "properties": {
+                "domains": {
+                    "description": "需要处理的 CDN 域名",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "fatherDomain": {
+                    "description": "父域名,只指定父域名时处理该父域名下的所有域名",
+                    "type": "string"
+                },
+                "force": {
+                    "description": "忽略过期检查,强制申请新证书",
+                    "type": "boolean"
+                }
+            }
+        },
+        "response.CleanupCertResp": {
+            "type": "object",
+            "properties": {
+                "certId": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "notAfter": {
+                    "description": "证书过期时间",
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "删除或保留的原因,删除失败时为错误信息",
+                    "type": "string"
+                }
+            }
+        },
+        "response.CleanupResp": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "description": "已删除(或预演时将要删除)的证书",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.CleanupCertResp"
+                    }
+                },
+                "dryRun": {
+                    "description": "是否为预演",
+                    "type": "boolean"
+                },
+                "failed": {
+                    "description": "删除失败的证书",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.CleanupCertResp"
+                    }
+                },
+                "kept": {
+                    "description": "保留的证书",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.CleanupCertResp"
+                    }
+                }
+            }
+        },
         "response.GetConfResp": {
             "type": "object",
             "properties": {
@@ -119,6 +573,124 @@ const docTemplate = `{
                 }
             }
         },
+        "response.GetScheduleResp": {
+            "type": "object",
+            "properties": {
+                "jitter": {
+                    "description": "随机推迟的最大时长",
+                    "type": "string"
+                },
+                "nextRun": {
+                    "description": "下一次计划执行的时间",
+                    "type": "string"
+                },
+                "runOnStart": {
+                    "description": "启动后是否立即执行",
+                    "type": "boolean"
+                },
+                "spec": {
+                    "description": "cron 表达式",
+                    "type": "string"
+                },
+                "timeZone": {
+                    "description": "时区",
+                    "type": "string"
+                }
+            }
+        },
+        "response.ListRunsResp": {
+            "type": "object",
+            "properties": {
+                "runs": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.RunResp"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "response.PlanGroupResp": {
+            "type": "object",
+            "properties": {
+                "certName": {
+                    "description": "证书名称,未拆分的分组与父域名相同",
+                    "type": "string"
+                },
+                "currentCertId": {
+                    "description": "当前仍然可用的证书 id",
+                    "type": "string"
+                },
+                "dnsProvider": {
+                    "description": "申请证书时 DNS-01 验证使用的服务商",
+                    "type": "string"
+                },
+                "domains": {
+                    "description": "本轮需要处理的域名",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                },
+                "fatherDomain": {
+                    "type": "string"
+                },
+                "obtainCert": {
+                    "description": "是否会申请新证书",
+                    "type": "boolean"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "removeCertId": {
+                    "description": "将要删除的旧证书 id",
+                    "type": "string"
+                },
+                "sans": {
+                    "description": "覆盖本轮域名所需的证书名称",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "sslizeDomains": {
+                    "description": "将要绑定证书的域名",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "uploadCert": {
+                    "description": "是否会上传新证书",
+                    "type": "boolean"
+                }
+            }
+        },
+        "response.PlanResp": {
+            "type": "object",
+            "properties": {
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.PlanGroupResp"
+                    }
+                }
+            }
+        },
+        "response.RenewalResp": {
+            "type": "object",
+            "properties": {
+                "jobId": {
+                    "description": "任务 id,可以通过 /renewals/{id} 轮询进度",
+                    "type": "integer"
+                }
+            }
+        },
         "response.Resp": {
             "type": "object",
             "properties": {
@@ -130,6 +702,111 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "response.RunGroupResp": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "description": "执行的次数,包含重试",
+                    "type": "integer"
+                },
+                "certId": {
+                    "type": "string"
+                },
+                "certName": {
+                    "description": "证书名称,未拆分的分组与父域名相同",
+                    "type": "string"
+                },
+                "code": {
+                    "description": "责任链返回的 code",
+                    "type": "integer"
+                },
+                "domains": {
+                    "description": "本轮需要处理的域名",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "error": {
+                    "type": "string"
+                },
+                "failedDomains": {
+                    "description": "处理失败的域名",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "fatherDomain": {
+                    "type": "string"
+                },
+                "issued": {
+                    "description": "本轮是否申请了新证书",
+                    "type": "boolean"
+                },
+                "oldCertId": {
+                    "type": "string"
+                },
+                "stage": {
+                    "description": "责任链到达的阶段",
+                    "type": "string"
+                }
+            }
+        },
+        "response.RunResp": {
+            "type": "object",
+            "properties": {
+                "endedAt": {
+                    "description": "结束时间",
+                    "type": "string"
+                },
+                "error": {
+                    "description": "整轮失败时的错误信息",
+                    "type": "string"
+                },
+                "groups": {
+                    "description": "各父域名分组的结果",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.RunGroupResp"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "skipped": {
+                    "description": "被过滤规则跳过的域名",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/response.SkippedDomainResp"
+                    }
+                },
+                "startedAt": {
+                    "description": "开始时间",
+                    "type": "string"
+                },
+                "status": {
+                    "description": "running/success/failed",
+                    "type": "string"
+                },
+                "trigger": {
+                    "description": "触发方式",
+                    "type": "string"
+                }
+            }
+        },
+        "response.SkippedDomainResp": {
+            "type": "object",
+            "properties": {
+                "domain": {
+                    "type": "string"
+                },
+                "reason": {
+                    "description": "跳过的原因",
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -1,16 +1,46 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"github.com/gin-gonic/gin"
 	"github.com/muxi-Infra/autossl-qiniuyun/config"
 	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
+// shutdownTimeout 收到退出信号后等待正在进行的续期任务和 HTTP 请求结束的最长时间,
+// 比责任链的宽限期多留出写入执行记录的时间
+const shutdownTimeout = cron.ShutdownGrace + 5*time.Second
+
 func main() {
+	plan := flag.Bool("plan", false, "只预演一轮续期任务并输出结果,不会申请、上传、绑定或删除任何证书")
+	flag.Parse()
+
 	config.InitViper("./config")
 	app := InitApp()
-	app.Serve()
-	return
+
+	//SIGINT/SIGTERM 会取消这个上下文,定时任务随之停止派发新的分组,已经开始的分组在宽限期内继续执行
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *plan {
+		if err := app.Plan(ctx); err != nil {
+			log.Fatal("预演失败:", err)
+		}
+		return
+	}
+
+	if err := app.Serve(ctx); err != nil {
+		log.Println("服务异常退出:", err)
+	}
 }
 
 type App struct {
@@ -25,7 +55,72 @@ func NewApp(cron cron.Corn, g *gin.Engine) *App {
 	}
 }
 
-func (app *App) Serve() {
-	app.corn.Start()
-	app.g.Run(":8080")
+// Serve 同时运行定时任务和 HTTP 服务,任意一方退出或收到退出信号时整体关闭
+func (app *App) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	srv := &http.Server{
+		Addr:    ":8080",
+		Handler: app.g,
+	}
+
+	//启动定时任务
+	cornDone := make(chan struct{})
+	go func() {
+		defer close(cornDone)
+		app.corn.Start(ctx)
+	}()
+
+	//启动 HTTP 服务
+	srvErr := make(chan error, 1)
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			srvErr <- err
+		}
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		log.Println("收到退出信号,开始关闭服务...")
+	case err = <-srvErr:
+		//HTTP 服务启动失败时也需要让定时任务停下来
+		cancel()
+	}
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+
+	if e := srv.Shutdown(shutdownCtx); e != nil {
+		log.Println("关闭 HTTP 服务失败:", e)
+	}
+
+	//等待正在进行的定时任务和手动续期任务结束
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		<-cornDone
+		app.corn.Wait()
+	}()
+	select {
+	case <-jobsDone:
+		log.Println("续期任务已停止")
+	case <-shutdownCtx.Done():
+		log.Println("等待续期任务结束超时,强制退出")
+	}
+
+	return err
+}
+
+// Plan 预演一轮续期任务并以 JSON 格式输出到标准输出
+func (app *App) Plan(ctx context.Context) error {
+	plans, err := app.corn.Plan(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plans)
 }
@@ -0,0 +1,76 @@
+package qiniu
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 七牛云过载时返回的状态码
+const StatusOverloaded = 573
+
+// QiniuError 七牛云接口返回的非 2xx 响应
+type QiniuError struct {
+	StatusCode int    // HTTP 状态码
+	Code       int    // 七牛云错误码
+	Message    string // 错误信息
+	RequestID  string // 请求 id,即响应头中的 X-Reqid,排查问题时提供给七牛云
+}
+
+func (e *QiniuError) Error() string {
+	return fmt.Sprintf("qiniu: status=%d code=%d message=%s reqid=%s", e.StatusCode, e.Code, e.Message, e.RequestID)
+}
+
+// 七牛云错误响应的 body
+type errorBody struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+// newQiniuError 从响应中解析错误,body 不是 JSON 时直接把内容作为错误信息
+func newQiniuError(resp *http.Response, body []byte) *QiniuError {
+	e := &QiniuError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get("X-Reqid"),
+	}
+
+	var b errorBody
+	if err := json.Unmarshal(body, &b); err == nil && (b.Code != 0 || b.Error != "") {
+		e.Code = b.Code
+		e.Message = b.Error
+	} else {
+		e.Message = string(body)
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// IsNotFound 判断是否为资源不存在
+func IsNotFound(err error) bool {
+	var e *QiniuError
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.StatusCode == http.StatusNotFound || e.Code == http.StatusNotFound
+}
+
+// IsRateLimited 判断是否被限流或者七牛云过载
+func IsRateLimited(err error) bool {
+	var e *QiniuError
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode == StatusOverloaded
+}
+
+// IsAuthError 判断是否为鉴权失败
+func IsAuthError(err error) bool {
+	var e *QiniuError
+	if !errors.As(err, &e) {
+		return false
+	}
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
@@ -0,0 +1,101 @@
+package qiniu
+
+import (
+	"golang.org/x/time/rate"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 接口族,每个接口族使用独立的令牌桶
+const (
+	FamilyDomain  = "domain"  // 域名查询
+	FamilySSLCert = "sslcert" // 证书的增删查
+	FamilySSLize  = "sslize"  // 为域名绑定证书
+)
+
+// RateLimit 单个接口族的令牌桶配置
+type RateLimit struct {
+	QPS   float64 // 每秒允许的请求数
+	Burst int     // 桶容量
+}
+
+// 未配置时使用的默认值,sslize 对应原先每个域名之间等待 3 秒
+var defaultRateLimits = map[string]RateLimit{
+	FamilyDomain:  {QPS: 5, Burst: 5},
+	FamilySSLCert: {QPS: 2, Burst: 2},
+	FamilySSLize:  {QPS: 1.0 / 3, Burst: 1},
+}
+
+const (
+	defaultMaxRetries = 3               // 被限流时最多重试的次数
+	defaultRetryDelay = time.Second     // 第一次重试前的等待时间,之后每次翻倍
+	maxRetryDelay     = 1 * time.Minute // 单次等待的上限
+)
+
+// WithRateLimit 设置某个接口族的令牌桶,QPS 小于等于 0 时保持默认值
+func WithRateLimit(family string, limit RateLimit) Option {
+	return func(c *QiniuClient) {
+		if limit.QPS <= 0 {
+			return
+		}
+		if limit.Burst <= 0 {
+			limit.Burst = 1
+		}
+		c.limiters[family] = rate.NewLimiter(rate.Limit(limit.QPS), limit.Burst)
+	}
+}
+
+// WithMaxRetries 设置遇到 429/573 时最多重试的次数,小于 0 时保持默认值
+func WithMaxRetries(n int) Option {
+	return func(c *QiniuClient) {
+		if n >= 0 {
+			c.maxRetries = n
+		}
+	}
+}
+
+// newLimiters 按默认值为每个接口族创建令牌桶
+func newLimiters() map[string]*rate.Limiter {
+	limiters := make(map[string]*rate.Limiter, len(defaultRateLimits))
+	for family, limit := range defaultRateLimits {
+		limiters[family] = rate.NewLimiter(rate.Limit(limit.QPS), limit.Burst)
+	}
+	return limiters
+}
+
+// familyOf 根据请求路径判断所属的接口族
+func familyOf(path string) string {
+	switch {
+	case strings.HasPrefix(path, "/sslcert"):
+		return FamilySSLCert
+	case strings.Contains(path, "/sslize"), strings.Contains(path, "/httpsconf"):
+		return FamilySSLize
+	default:
+		return FamilyDomain
+	}
+}
+
+// isRetryableStatus 429 和七牛云过载时返回的 573 可以等待后重试
+func isRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code == StatusOverloaded
+}
+
+// retryDelay 计算第 attempt 次重试前的等待时间,优先使用响应头中的 Retry-After
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds >= 0 {
+			return min(time.Duration(seconds)*time.Second, maxRetryDelay)
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			return min(max(time.Until(t), 0), maxRetryDelay)
+		}
+	}
+
+	d := defaultRetryDelay << attempt
+	if d <= 0 || d > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return d
+}
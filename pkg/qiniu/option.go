@@ -0,0 +1,81 @@
+package qiniu
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	QiniuBaseUrl     = "https://api.qiniu.com" // 七牛云 API 的默认地址
+	defaultTimeout   = 30 * time.Second        // 单次请求的默认超时时间
+	defaultUserAgent = "autossl-qiniuyun"
+)
+
+// Option 修改 QiniuClient 的配置
+type Option func(*QiniuClient)
+
+// WithBaseURL 设置 API 地址,可以指向测试服务器,为空时保持默认值
+func WithBaseURL(baseURL string) Option {
+	return func(c *QiniuClient) {
+		if baseURL != "" {
+			c.baseURL = strings.TrimRight(baseURL, "/")
+		}
+	}
+}
+
+// WithHTTPClient 设置发送请求使用的 http.Client,为 nil 时保持默认值
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *QiniuClient) {
+		if client != nil {
+			c.client = client
+		}
+	}
+}
+
+// WithTimeout 设置单次请求的超时时间,重试时每次请求单独计时,小于等于 0 时保持默认值
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *QiniuClient) {
+		if timeout > 0 {
+			c.timeout = timeout
+		}
+	}
+}
+
+// WithProxy 设置出站代理,为 nil 时保持默认值(读取 HTTP_PROXY 等环境变量)
+func WithProxy(proxy *url.URL) Option {
+	return func(c *QiniuClient) {
+		if proxy != nil {
+			c.proxy = proxy
+		}
+	}
+}
+
+// WithUserAgent 设置请求的 User-Agent,为空时保持默认值
+func WithUserAgent(userAgent string) Option {
+	return func(c *QiniuClient) {
+		if userAgent != "" {
+			c.userAgent = userAgent
+		}
+	}
+}
+
+// withProxy 返回使用指定代理的 http.Client 副本,不会修改传入的 client
+func withProxy(client *http.Client, proxy *url.URL) *http.Client {
+	var transport *http.Transport
+	switch t := client.Transport.(type) {
+	case nil:
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		// 自定义的 RoundTripper 无法设置代理,由调用方自行处理
+		return client
+	}
+	transport.Proxy = http.ProxyURL(proxy)
+
+	clone := *client
+	clone.Transport = transport
+	return &clone
+}
@@ -0,0 +1,80 @@
+package qiniu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 域名的操作状态,具体请看：https://developer.qiniu.com/fusion/4246/the-domain-name#11
+const (
+	OperatingStateProcessing = "processing" // 修改正在生效
+	OperatingStateSuccess    = "success"    // 修改已经生效
+)
+
+const (
+	defaultPollInterval     = 5 * time.Second // 查询域名状态的间隔
+	defaultOperationTimeout = 10 * time.Minute
+)
+
+// ErrOperationTimeout 等待域名操作完成超时
+var ErrOperationTimeout = errors.New("qiniu: 等待域名操作完成超时")
+
+// DomainStateError 域名操作结束后没有处于 success 状态
+type DomainStateError struct {
+	Domain string
+	State  string
+	Desc   string // 七牛云返回的状态说明
+}
+
+func (e *DomainStateError) Error() string {
+	return fmt.Sprintf("qiniu: 域名 %s 处于 %s 状态: %s", e.Domain, e.State, e.Desc)
+}
+
+// WithPollInterval 设置等待域名操作完成时查询状态的间隔,小于等于 0 时保持默认值
+func WithPollInterval(interval time.Duration) Option {
+	return func(c *QiniuClient) {
+		if interval > 0 {
+			c.pollInterval = interval
+		}
+	}
+}
+
+// WithOperationTimeout 设置等待单个域名操作完成的最长时间,小于等于 0 时保持默认值
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(c *QiniuClient) {
+		if timeout > 0 {
+			c.operationTimeout = timeout
+		}
+	}
+}
+
+// WaitDomainReady 轮询域名状态直到 operatingState 不再是 processing,
+// 最终状态为 success 时返回域名详情,否则返回 DomainStateError,超时返回 ErrOperationTimeout
+func (c *QiniuClient) WaitDomainReady(ctx context.Context, name string) (DomainDetail, error) {
+	deadline := time.Now().Add(c.operationTimeout)
+	for {
+		detail, err := c.GetDomain(ctx, name)
+		if err != nil {
+			return DomainDetail{}, err
+		}
+
+		switch detail.OperatingState {
+		case OperatingStateSuccess:
+			return detail, nil
+		case OperatingStateProcessing:
+		default:
+			return detail, &DomainStateError{Domain: name, State: detail.OperatingState, Desc: detail.OperatingStateDesc}
+		}
+
+		if !time.Now().Add(c.pollInterval).Before(deadline) {
+			return detail, fmt.Errorf("%w: %s", ErrOperationTimeout, name)
+		}
+		select {
+		case <-ctx.Done():
+			return DomainDetail{}, ctx.Err()
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
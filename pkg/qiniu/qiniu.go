@@ -1,28 +1,92 @@
 package qiniu
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/qiniu/go-sdk/v7/auth"
+	"golang.org/x/time/rate"
+	"iter"
 	"net/http"
+	"net/url"
+	"time"
 )
 
-func NewQiniuClient(accessKey string, secretKey string) *QiniuClient {
-	return &QiniuClient{
+func NewQiniuClient(accessKey string, secretKey string, opts ...Option) *QiniuClient {
+	c := &QiniuClient{
 		qiniuClient: auth.New(accessKey, secretKey),
 		client:      http.DefaultClient,
+		baseURL:     QiniuBaseUrl,
+		timeout:     defaultTimeout,
+		userAgent:   defaultUserAgent,
+		limiters:    newLimiters(),
+		maxRetries:  defaultMaxRetries,
+
+		pollInterval:     defaultPollInterval,
+		operationTimeout: defaultOperationTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.proxy != nil {
+		c.client = withProxy(c.client, c.proxy)
 	}
+	return c
 }
 
-const QiniuBaseUrl = "https://api.qiniu.com"
-
 type QiniuClient struct {
 	qiniuClient *auth.Credentials
 	client      *http.Client
+	baseURL     string                   // API 地址
+	timeout     time.Duration            // 单次请求的超时时间
+	proxy       *url.URL                 // 出站代理
+	userAgent   string                   // 请求的 User-Agent
+	limiters    map[string]*rate.Limiter // 按接口族限流
+	maxRetries  int                      // 遇到 429/573 时最多重试的次数
+
+	pollInterval     time.Duration // 等待域名操作完成时查询状态的间隔
+	operationTimeout time.Duration // 等待单个域名操作完成的最长时间
+}
+
+// 获取所有域名,会按照 marker 自动翻页直到取完
+func (c *QiniuClient) GetDomainList(ctx context.Context) (GetDomainResp, error) {
+	var resp GetDomainResp
+	for domain, err := range c.Domains(ctx) {
+		if err != nil {
+			return GetDomainResp{}, err
+		}
+		resp.Domains = append(resp.Domains, domain)
+	}
+	return resp, nil
+}
+
+// 逐页遍历所有域名,遇到错误时产出错误并结束遍历
+func (c *QiniuClient) Domains(ctx context.Context) iter.Seq2[Domain, error] {
+	return func(yield func(Domain, error) bool) {
+		marker := ""
+		for {
+			page, err := c.getDomainPage(ctx, marker)
+			if err != nil {
+				yield(Domain{}, err)
+				return
+			}
+			for _, domain := range page.Domains {
+				if !yield(domain, nil) {
+					return
+				}
+			}
+			//marker 为空或者没有变化说明已经是最后一页
+			if page.Marker == "" || page.Marker == marker {
+				return
+			}
+			marker = page.Marker
+		}
+	}
 }
 
-func (c *QiniuClient) GetDomainList() (GetDomainResp, error) {
+// 获取一页域名
+func (c *QiniuClient) getDomainPage(ctx context.Context, marker string) (GetDomainResp, error) {
 	var resp GetDomainResp
-	data, err := c.newReq(http.MethodGet, "/domain", GetDomainReq{Limit: 1000})
+	data, err := c.newReq(ctx, http.MethodGet, "/domain", GetDomainReq{Marker: marker, Limit: 1000})
 	if err != nil {
 		return GetDomainResp{}, err
 	}
@@ -35,9 +99,9 @@ func (c *QiniuClient) GetDomainList() (GetDomainResp, error) {
 }
 
 // 上传ssl证书
-func (c *QiniuClient) UPSSLCert(pri, ca, name string) (UPSSLCertResp, error) {
+func (c *QiniuClient) UPSSLCert(ctx context.Context, pri, ca, name string) (UPSSLCertResp, error) {
 	var resp UPSSLCertResp
-	data, err := c.newReq(http.MethodPost, "/sslcert", UPSSLCertReq{Name: name, CommonName: name, Pri: pri, Ca: ca})
+	data, err := c.newReq(ctx, http.MethodPost, "/sslcert", UPSSLCertReq{Name: name, CommonName: name, Pri: pri, Ca: ca})
 	if err != nil {
 		return UPSSLCertResp{}, err
 	}
@@ -49,10 +113,46 @@ func (c *QiniuClient) UPSSLCert(pri, ca, name string) (UPSSLCertResp, error) {
 	return resp, nil
 }
 
-// 获取ssl证书列表
-func (c *QiniuClient) GETSSLCertList() (GetSSLCertListResp, error) {
+// 获取所有ssl证书,会按照 marker 自动翻页直到取完
+func (c *QiniuClient) GETSSLCertList(ctx context.Context) (GetSSLCertListResp, error) {
+	var resp GetSSLCertListResp
+	for cert, err := range c.SSLCerts(ctx) {
+		if err != nil {
+			return GetSSLCertListResp{}, err
+		}
+		resp.Certs = append(resp.Certs, cert)
+	}
+	return resp, nil
+}
+
+// 逐页遍历所有ssl证书,遇到错误时产出错误并结束遍历
+func (c *QiniuClient) SSLCerts(ctx context.Context) iter.Seq2[Cert, error] {
+	return func(yield func(Cert, error) bool) {
+		marker := ""
+		for {
+			page, err := c.getSSLCertPage(ctx, marker)
+			if err != nil {
+				yield(Cert{}, err)
+				return
+			}
+			for _, cert := range page.Certs {
+				if !yield(cert, nil) {
+					return
+				}
+			}
+			//marker 为空或者没有变化说明已经是最后一页
+			if page.Marker == "" || page.Marker == marker {
+				return
+			}
+			marker = page.Marker
+		}
+	}
+}
+
+// 获取一页ssl证书
+func (c *QiniuClient) getSSLCertPage(ctx context.Context, marker string) (GetSSLCertListResp, error) {
 	var resp GetSSLCertListResp
-	data, err := c.newReq(http.MethodGet, "/sslcert", GetSSLCertListReq{Limit: 500})
+	data, err := c.newReq(ctx, http.MethodGet, "/sslcert", GetSSLCertListReq{Marker: marker, Limit: 500})
 	if err != nil {
 		return GetSSLCertListResp{}, err
 	}
@@ -65,10 +165,10 @@ func (c *QiniuClient) GETSSLCertList() (GetSSLCertListResp, error) {
 }
 
 // 使用certId获取ssl证书
-func (c *QiniuClient) GETSSLCertById(certId string) (GetSSLCertByIDResp, error) {
+func (c *QiniuClient) GETSSLCertById(ctx context.Context, certId string) (GetSSLCertByIDResp, error) {
 	var resp GetSSLCertByIDResp
-	//如果存在则不会报错,这里没有去查错误码 TODO 使用错误码进行精确对应
-	data, err := c.newReq(http.MethodGet, "/sslcert/"+certId, nil)
+	//证书不存在时返回的错误可以用 IsNotFound 判断
+	data, err := c.newReq(ctx, http.MethodGet, "/sslcert/"+certId, nil)
 	if err != nil {
 		return GetSSLCertByIDResp{}, err
 	}
@@ -80,22 +180,76 @@ func (c *QiniuClient) GETSSLCertById(certId string) (GetSSLCertByIDResp, error)
 	return resp, nil
 }
 
-// 删除证书
-func (c *QiniuClient) RemoveSSLCert(certId string) error {
-	_, err := c.newReq(http.MethodPost, "/sslcert/"+certId, nil)
+// 删除证书,七牛云的接口为 DELETE /sslcert/<id>,早期版本误用了 POST
+func (c *QiniuClient) RemoveSSLCert(ctx context.Context, certId string) error {
+	_, err := c.newReq(ctx, http.MethodDelete, "/sslcert/"+certId, nil)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// 获取单个域名的详情
+func (c *QiniuClient) GetDomain(ctx context.Context, name string) (DomainDetail, error) {
+	var resp DomainDetail
+	data, err := c.newReq(ctx, http.MethodGet, "/domain/"+name, nil)
+	if err != nil {
+		return DomainDetail{}, err
+	}
+
+	err = json.Unmarshal(data, &resp)
+	if err != nil {
+		return DomainDetail{}, err
+	}
+	return resp, nil
+}
+
+// 为域名绑定证书:http 域名通过 sslize 开启 https,已经是 https 的域名通过 httpsconf 更换证书,
+// 域名已经绑定该证书且选项没有变化时不会发起修改,此时返回的 changed 为 false。
+// 修改提交后域名会进入 processing 状态,需要通过 WaitDomainReady 等待生效
+func (c *QiniuClient) BindCert(ctx context.Context, name, certID string, opts HTTPSOptions) (changed bool, err error) {
+	detail, err := c.GetDomain(ctx, name)
+	if err != nil {
+		return false, err
+	}
+
+	//上一次修改尚未生效时七牛云会拒绝新的修改,先等待其完成
+	if detail.OperatingState == OperatingStateProcessing {
+		detail, err = c.WaitDomainReady(ctx, name)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	forceHttps, http2Enable := opts.resolve(detail.HTTPS)
+	if detail.HTTPS.CertID == certID && detail.HTTPS.ForceHttps == forceHttps && detail.HTTPS.Http2Enable == http2Enable {
+		return false, nil
+	}
+
+	req := ForceHTTPSReq{CertId: certID, ForceHttps: forceHttps, Http2Enable: http2Enable}
+	if detail.Protocol == "https" {
+		err = c.UpdateHTTPSConf(ctx, name, req)
+	} else {
+		err = c.ForceHTTPS(ctx, name, req)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// 为 http 域名绑定证书并开启https
+func (c *QiniuClient) ForceHTTPS(ctx context.Context, name string, req ForceHTTPSReq) error {
+	_, err := c.newReq(ctx, http.MethodPut, "/domain/"+name+"/sslize", req)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// 修改绑定的证书并开启https
-func (c *QiniuClient) ForceHTTPS(name, certID string) error {
-	_, err := c.newReq(http.MethodPut, "/domain/"+name+"/sslize", ForceHTTPSReq{
-		CertId:      certID,
-		ForceHttps:  false, //默认关闭强制https
-		Http2Enable: false, //默认关闭http2强制
-	})
+// 修改 https 域名的证书和 https 配置
+func (c *QiniuClient) UpdateHTTPSConf(ctx context.Context, name string, req ForceHTTPSReq) error {
+	_, err := c.newReq(ctx, http.MethodPut, "/domain/"+name+"/httpsconf", req)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,278 @@
+package qiniu_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu/qiniutest"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const (
+	testAK = "test-ak"
+	testSK = "test-sk"
+)
+
+func newServer(t *testing.T) *qiniutest.Server {
+	t.Helper()
+	srv := qiniutest.NewServer(testAK, testSK)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// addCert 向模拟服务添加一张证书,返回证书 id
+func addCert(t *testing.T, srv *qiniutest.Server, name string, notAfter time.Time) string {
+	t.Helper()
+	certPEM, keyPEM, err := qiniutest.NewCertPEM([]string{name}, notAfter.Add(-90*24*time.Hour), notAfter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := srv.AddCert(name, certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestDomainsPagination(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		pages int
+	}{
+		{"empty", 0, 1},
+		{"single page", 999, 1},
+		{"exactly one page", 1000, 1},
+		{"multiple pages", 2500, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			for i := 0; i < tt.total; i++ {
+				srv.AddDomain(qiniu.DomainDetail{Domain: qiniu.Domain{Name: fmt.Sprintf("d%04d.example.com", i)}})
+			}
+
+			resp, err := srv.Client().GetDomainList(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Domains) != tt.total {
+				t.Fatalf("got %d domains, want %d", len(resp.Domains), tt.total)
+			}
+			seen := make(map[string]bool)
+			for _, d := range resp.Domains {
+				if seen[d.Name] {
+					t.Fatalf("domain %s returned twice", d.Name)
+				}
+				seen[d.Name] = true
+			}
+			if got := srv.Count(http.MethodGet, "/domain"); got != tt.pages {
+				t.Fatalf("got %d page requests, want %d", got, tt.pages)
+			}
+		})
+	}
+}
+
+func TestSSLCertsPagination(t *testing.T) {
+	tests := []struct {
+		name  string
+		total int
+		pages int
+	}{
+		{"empty", 0, 1},
+		{"exactly one page", 500, 1},
+		{"multiple pages", 1201, 3},
+	}
+
+	certPEM, keyPEM, err := qiniutest.NewCertPEM([]string{"*.example.com"}, time.Now(), time.Now().Add(90*24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			for i := 0; i < tt.total; i++ {
+				if _, err := srv.AddCert(fmt.Sprintf("cert%04d", i), certPEM, keyPEM); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp, err := srv.Client().GETSSLCertList(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Certs) != tt.total {
+				t.Fatalf("got %d certs, want %d", len(resp.Certs), tt.total)
+			}
+			if got := srv.Count(http.MethodGet, "/sslcert"); got != tt.pages {
+				t.Fatalf("got %d page requests, want %d", got, tt.pages)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		times      int // 故障生效的次数,0 表示一直生效
+		maxRetries int
+		wantErr    bool
+		requests   int
+	}{
+		{"429 then success", http.StatusTooManyRequests, 1, 3, false, 2},
+		{"573 then success", qiniu.StatusOverloaded, 1, 3, false, 2},
+		{"retries exhausted", http.StatusTooManyRequests, 0, 1, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.Inject(qiniutest.Fault{Path: "/sslcert", Status: tt.status, RetryAfter: time.Second, Times: tt.times})
+
+			start := time.Now()
+			_, err := srv.Client(qiniu.WithMaxRetries(tt.maxRetries)).GETSSLCertList(context.Background())
+			elapsed := time.Since(start)
+
+			if tt.wantErr {
+				if !qiniu.IsRateLimited(err) {
+					t.Fatalf("got %v, want rate limited error", err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			//每次重试之前都需要等待 Retry-After 指定的时间
+			if elapsed < time.Second {
+				t.Fatalf("retried after %v, want at least 1s", elapsed)
+			}
+			if got := srv.Count(http.MethodGet, "/sslcert"); got != tt.requests {
+				t.Fatalf("got %d requests, want %d", got, tt.requests)
+			}
+		})
+	}
+}
+
+func TestErrorClassification(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(srv *qiniutest.Server) *qiniu.QiniuClient
+		certId   string
+		notFound bool
+		auth     bool
+	}{
+		{
+			name:     "missing cert",
+			setup:    func(srv *qiniutest.Server) *qiniu.QiniuClient { return srv.Client() },
+			certId:   "missing",
+			notFound: true,
+		},
+		{
+			name: "wrong secret key",
+			setup: func(srv *qiniutest.Server) *qiniu.QiniuClient {
+				return qiniu.NewQiniuClient(testAK, "wrong", qiniu.WithBaseURL(srv.URL))
+			},
+			certId: "missing",
+			auth:   true,
+		},
+		{
+			name: "forbidden",
+			setup: func(srv *qiniutest.Server) *qiniu.QiniuClient {
+				srv.FailNext(http.MethodGet, "/sslcert/", http.StatusForbidden, 1)
+				return srv.Client()
+			},
+			certId: "missing",
+			auth:   true,
+		},
+		{
+			name: "server error",
+			setup: func(srv *qiniutest.Server) *qiniu.QiniuClient {
+				srv.FailNext(http.MethodGet, "/sslcert/", http.StatusInternalServerError, 1)
+				return srv.Client()
+			},
+			certId: "missing",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			_, err := tt.setup(srv).GETSSLCertById(context.Background(), tt.certId)
+			if err == nil {
+				t.Fatal("want error")
+			}
+			if got := qiniu.IsNotFound(err); got != tt.notFound {
+				t.Errorf("IsNotFound(%v) = %v, want %v", err, got, tt.notFound)
+			}
+			if got := qiniu.IsAuthError(err); got != tt.auth {
+				t.Errorf("IsAuthError(%v) = %v, want %v", err, got, tt.auth)
+			}
+		})
+	}
+}
+
+func TestGETSSLCertById(t *testing.T) {
+	srv := newServer(t)
+	notAfter := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	id := addCert(t, srv, "a.example.com", notAfter)
+
+	resp, err := srv.Client().GETSSLCertById(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//证书信息位于 cert 字段下
+	if resp.Cert.CertId != id || resp.Cert.Name != "a.example.com" {
+		t.Fatalf("got cert %q(%q), want %q", resp.Cert.CertId, resp.Cert.Name, id)
+	}
+	if got := time.Unix(resp.Cert.NotAfter, 0); !got.Equal(notAfter) {
+		t.Fatalf("got not_after %v, want %v", got, notAfter)
+	}
+	if resp.Cert.Ca == "" || resp.Cert.Pri == "" {
+		t.Fatal("want certificate and private key")
+	}
+}
+
+func TestBindCertWaitsForProcessing(t *testing.T) {
+	tests := []struct {
+		name  string
+		polls int // 每次修改后域名保持 processing 状态的查询次数
+	}{
+		{"no processing", 0},
+		{"processing once", 1},
+		{"processing several times", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newServer(t)
+			srv.SetProcessingPolls(tt.polls)
+			srv.AddDomain(qiniu.DomainDetail{Domain: qiniu.Domain{Name: "a.example.com"}})
+			first := addCert(t, srv, "first", time.Now().Add(30*24*time.Hour))
+			second := addCert(t, srv, "second", time.Now().Add(60*24*time.Hour))
+
+			client := srv.Client()
+			ctx := context.Background()
+			//第一次绑定后域名进入 processing 状态,第二次绑定需要等待其完成,否则模拟服务会拒绝修改
+			if changed, err := client.BindCert(ctx, "a.example.com", first, qiniu.HTTPSOptions{}); err != nil || !changed {
+				t.Fatalf("first bind: changed=%v err=%v", changed, err)
+			}
+			if changed, err := client.BindCert(ctx, "a.example.com", second, qiniu.HTTPSOptions{}); err != nil || !changed {
+				t.Fatalf("second bind: changed=%v err=%v", changed, err)
+			}
+
+			detail, err := client.WaitDomainReady(ctx, "a.example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if detail.Protocol != "https" || detail.HTTPS.CertID != second {
+				t.Fatalf("got protocol=%s cert=%s, want https with %s", detail.Protocol, detail.HTTPS.CertID, second)
+			}
+			if got := srv.Count(http.MethodPut, "/domain/a.example.com/httpsconf"); got != 1 {
+				t.Fatalf("got %d httpsconf requests, want 1", got)
+			}
+
+			//证书没有变化时不会再次修改
+			if changed, err := client.BindCert(ctx, "a.example.com", second, qiniu.HTTPSOptions{}); err != nil || changed {
+				t.Fatalf("third bind: changed=%v err=%v", changed, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package qiniutest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fault 注入的故障,命中的请求不会到达模拟的接口
+type Fault struct {
+	Method     string        // 只对该方法生效,为空时对所有方法生效
+	Path       string        // 只对以该前缀开头的路径生效,为空时对所有路径生效
+	Status     int           // 返回的 HTTP 状态码
+	Code       int           // 返回的七牛云错误码,为 0 时与 Status 相同
+	Message    string        // 返回的错误信息
+	RetryAfter time.Duration // 不为 0 时设置 Retry-After 响应头
+	Times      int           // 生效的次数,为 0 时一直生效
+}
+
+// Inject 注入一个故障,多个故障按注入顺序匹配
+func (s *Server) Inject(f Fault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, &f)
+}
+
+// FailNext 让接下来 times 次匹配 method 和 path 前缀的请求返回 status
+func (s *Server) FailNext(method, path string, status, times int) {
+	s.Inject(Fault{Method: method, Path: path, Status: status, Times: times})
+}
+
+// RateLimit 让接下来 times 次匹配 path 前缀的请求返回 429,并带上 Retry-After
+func (s *Server) RateLimit(path string, times int, retryAfter time.Duration) {
+	s.Inject(Fault{Path: path, Status: http.StatusTooManyRequests, Message: "too many requests", RetryAfter: retryAfter, Times: times})
+}
+
+// ClearFaults 清除所有注入的故障
+func (s *Server) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = nil
+}
+
+// takeFault 返回第一个命中请求的故障并扣减其剩余次数
+func (s *Server) takeFault(r *http.Request) *Fault {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, f := range s.faults {
+		if f.Method != "" && !strings.EqualFold(f.Method, r.Method) {
+			continue
+		}
+		if !strings.HasPrefix(r.URL.Path, f.Path) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		hit := *f
+		return &hit
+	}
+	return nil
+}
+
+// write 写入故障对应的响应
+func (f *Fault) write(w http.ResponseWriter) {
+	if f.RetryAfter > 0 {
+		seconds := int((f.RetryAfter + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+	code := f.Code
+	if code == 0 {
+		code = f.Status
+	}
+	message := f.Message
+	if message == "" {
+		message = http.StatusText(f.Status)
+	}
+	writeError(w, f.Status, code, message)
+}
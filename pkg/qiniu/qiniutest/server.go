@@ -0,0 +1,445 @@
+// Package qiniutest 提供一个运行在 httptest.Server 上的七牛云 API 模拟服务,
+// 用于在没有七牛云账号的情况下离线测试 QiniuClient 以及 cron 中的责任链
+package qiniutest
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/muxi-Infra/autossl-qiniuyun/pkg/qiniu"
+	"github.com/qiniu/go-sdk/v7/auth"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request 模拟服务收到的一次请求
+type Request struct {
+	Method string
+	Path   string
+}
+
+// Cert 模拟服务中保存的证书
+type Cert struct {
+	qiniu.Cert
+	CommonName string
+	DNSNames   []string
+	Pri        string
+	Ca         string
+}
+
+// Server 有状态的七牛云 API 模拟服务,所有方法都可以并发调用
+type Server struct {
+	*httptest.Server
+
+	accessKey string
+	secretKey string
+	creds     *auth.Credentials
+
+	mu         sync.Mutex
+	domains    map[string]*qiniu.DomainDetail
+	processing map[string]int // 域名还会以 processing 状态返回的次数
+	certs      map[string]*Cert
+	nextCertID int
+	faults     []*Fault
+	latency    time.Duration
+	requests   []Request
+
+	processingPolls int // 每次修改证书后域名保持 processing 状态的查询次数
+}
+
+// NewServer 启动一个模拟服务,只接受使用 accessKey/secretKey 签名的请求,使用完毕后需要调用 Close
+func NewServer(accessKey, secretKey string) *Server {
+	s := &Server{
+		accessKey:       accessKey,
+		secretKey:       secretKey,
+		creds:           auth.New(accessKey, secretKey),
+		domains:         make(map[string]*qiniu.DomainDetail),
+		processing:      make(map[string]int),
+		certs:           make(map[string]*Cert),
+		processingPolls: 1,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /domain", s.listDomains)
+	mux.HandleFunc("GET /domain/{name}", s.getDomain)
+	mux.HandleFunc("PUT /domain/{name}/sslize", s.sslize)
+	mux.HandleFunc("PUT /domain/{name}/httpsconf", s.httpsconf)
+	mux.HandleFunc("GET /sslcert", s.listCerts)
+	mux.HandleFunc("POST /sslcert", s.uploadCert)
+	mux.HandleFunc("GET /sslcert/{id}", s.getCert)
+	mux.HandleFunc("DELETE /sslcert/{id}", s.deleteCert)
+
+	s.Server = httptest.NewServer(s.middleware(mux))
+	return s
+}
+
+// Client 返回连接到模拟服务的 QiniuClient,默认不限流并缩短轮询间隔,opts 可以覆盖这些默认值
+func (s *Server) Client(opts ...qiniu.Option) *qiniu.QiniuClient {
+	unlimited := qiniu.RateLimit{QPS: 1000, Burst: 1000}
+	defaults := []qiniu.Option{
+		qiniu.WithBaseURL(s.URL),
+		qiniu.WithHTTPClient(s.Server.Client()),
+		qiniu.WithRateLimit(qiniu.FamilyDomain, unlimited),
+		qiniu.WithRateLimit(qiniu.FamilySSLCert, unlimited),
+		qiniu.WithRateLimit(qiniu.FamilySSLize, unlimited),
+		qiniu.WithPollInterval(10 * time.Millisecond),
+	}
+	return qiniu.NewQiniuClient(s.accessKey, s.secretKey, append(defaults, opts...)...)
+}
+
+// SetLatency 设置每个请求返回前的延迟
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetProcessingPolls 设置每次修改证书后,域名详情接口以 processing 状态返回的次数,默认为 1
+func (s *Server) SetProcessingPolls(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.processingPolls = n
+}
+
+// Requests 返回到目前为止收到的所有请求
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Count 返回到目前为止收到的指定方法和路径的请求数量
+func (s *Server) Count(method, path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if r.Method == method && r.Path == path {
+			n++
+		}
+	}
+	return n
+}
+
+// AddDomain 添加一个域名,未填写的 Type、Platform、Protocol、OperatingState 使用 normal、web、http、success
+func (s *Server) AddDomain(d qiniu.DomainDetail) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if d.Type == "" {
+		d.Type = "normal"
+	}
+	if d.Platform == "" {
+		d.Platform = "web"
+	}
+	if d.Protocol == "" {
+		d.Protocol = "http"
+	}
+	if d.OperatingState == "" {
+		d.OperatingState = qiniu.OperatingStateSuccess
+	}
+	if d.CreateAt == "" {
+		d.CreateAt = time.Now().Format(time.RFC3339)
+	}
+	s.domains[d.Name] = &d
+}
+
+// Domain 返回域名当前的详情
+func (s *Server) Domain(name string) (qiniu.DomainDetail, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.domains[name]
+	if !ok {
+		return qiniu.DomainDetail{}, false
+	}
+	return *d, true
+}
+
+// AddCert 直接添加一张证书,返回证书 id
+func (s *Server) AddCert(name, certPEM, keyPEM string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.addCert(qiniu.UPSSLCertReq{Name: name, CommonName: name, Pri: keyPEM, Ca: certPEM})
+}
+
+// Cert 返回证书,证书不存在时第二个返回值为 false
+func (s *Server) Cert(id string) (Cert, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.certs[id]
+	if !ok {
+		return Cert{}, false
+	}
+	return *c, true
+}
+
+// CertIDs 按上传顺序返回所有证书 id
+func (s *Server) CertIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sortedCertIDs()
+}
+
+// middleware 依次处理请求记录、延迟、鉴权和故障注入
+func (s *Server) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path})
+		latency := s.latency
+		s.mu.Unlock()
+
+		if latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(latency):
+			}
+		}
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), auth.AuthorizationPrefixQBox) {
+			writeError(w, http.StatusUnauthorized, 401, "bad token")
+			return
+		}
+		if ok, err := s.creds.VerifyCallback(r); err != nil || !ok {
+			writeError(w, http.StatusUnauthorized, 401, "bad token")
+			return
+		}
+
+		if f := s.takeFault(r); f != nil {
+			f.write(w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) listDomains(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.domains))
+	for name := range s.domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	start, end, marker := page(r, len(names))
+	resp := qiniu.GetDomainResp{Marker: marker, Domains: []qiniu.Domain{}}
+	for _, name := range names[start:end] {
+		resp.Domains = append(resp.Domains, s.domainView(name).Domain)
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) getDomain(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := r.PathValue("name")
+	if _, ok := s.domains[name]; !ok {
+		writeError(w, http.StatusNotFound, 404, "no such domain")
+		return
+	}
+	view := s.domainView(name)
+	if s.processing[name] > 0 {
+		s.processing[name]--
+	}
+	writeJSON(w, view)
+}
+
+func (s *Server) sslize(w http.ResponseWriter, r *http.Request) {
+	s.bindCert(w, r, "http")
+}
+
+func (s *Server) httpsconf(w http.ResponseWriter, r *http.Request) {
+	s.bindCert(w, r, "https")
+}
+
+// bindCert 处理 sslize 和 httpsconf,protocol 为接口要求的域名当前协议
+func (s *Server) bindCert(w http.ResponseWriter, r *http.Request, protocol string) {
+	var req qiniu.ForceHTTPSReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, 400, "invalid json: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := r.PathValue("name")
+	d, ok := s.domains[name]
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, 404, "no such domain")
+		return
+	case s.processing[name] > 0:
+		writeError(w, http.StatusBadRequest, 400, "domain is processing, please try again later")
+		return
+	case d.Protocol != protocol:
+		writeError(w, http.StatusBadRequest, 400, fmt.Sprintf("domain protocol is %s", d.Protocol))
+		return
+	}
+	if _, ok := s.certs[req.CertId]; !ok {
+		writeError(w, http.StatusBadRequest, 400, "cert not found")
+		return
+	}
+
+	d.Protocol = "https"
+	d.HTTPS = qiniu.HTTPSConf{CertID: req.CertId, ForceHttps: req.ForceHttps, Http2Enable: req.Http2Enable}
+	s.processing[name] = s.processingPolls
+	writeJSON(w, map[string]any{})
+}
+
+func (s *Server) listCerts(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := s.sortedCertIDs()
+	start, end, marker := page(r, len(ids))
+	resp := qiniu.GetSSLCertListResp{Marker: marker, Certs: []qiniu.Cert{}}
+	for _, id := range ids[start:end] {
+		resp.Certs = append(resp.Certs, s.certs[id].Cert)
+	}
+	writeJSON(w, resp)
+}
+
+func (s *Server) uploadCert(w http.ResponseWriter, r *http.Request) {
+	var req qiniu.UPSSLCertReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, 400, "invalid json: "+err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, err := s.addCert(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, 400, err.Error())
+		return
+	}
+	writeJSON(w, qiniu.UPSSLCertResp{CertID: id})
+}
+
+func (s *Server) getCert(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.certs[r.PathValue("id")]
+	if !ok {
+		writeError(w, http.StatusNotFound, 404, "cert not found")
+		return
+	}
+	writeJSON(w, qiniu.GetSSLCertByIDResp{Cert: qiniu.CertDetail{
+		Cert:       c.Cert,
+		CommonName: c.CommonName,
+		DNSNames:   c.DNSNames,
+		Pri:        c.Pri,
+		Ca:         c.Ca,
+	}})
+}
+
+func (s *Server) deleteCert(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := r.PathValue("id")
+	if _, ok := s.certs[id]; !ok {
+		writeError(w, http.StatusNotFound, 404, "cert not found")
+		return
+	}
+	//和七牛云一致,仍然绑定在域名上的证书不允许删除
+	for name, d := range s.domains {
+		if d.HTTPS.CertID == id {
+			writeError(w, http.StatusBadRequest, 400, "cert is in use by domain "+name)
+			return
+		}
+	}
+	delete(s.certs, id)
+	writeJSON(w, map[string]any{})
+}
+
+// addCert 保存证书,有效期从证书链的第一张证书中解析,调用方需要持有 s.mu
+func (s *Server) addCert(req qiniu.UPSSLCertReq) (string, error) {
+	block, _ := pem.Decode([]byte(req.Ca))
+	if block == nil {
+		return "", fmt.Errorf("invalid certificate pem")
+	}
+	x, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate: %w", err)
+	}
+	if req.Pri == "" {
+		return "", fmt.Errorf("private key is required")
+	}
+
+	s.nextCertID++
+	id := fmt.Sprintf("fakecert%06d", s.nextCertID)
+	s.certs[id] = &Cert{
+		Cert: qiniu.Cert{
+			CertId:     id,
+			Name:       req.Name,
+			NotBefore:  x.NotBefore.Unix(),
+			NotAfter:   x.NotAfter.Unix(),
+			CreateTime: time.Now().Unix(),
+		},
+		CommonName: req.CommonName,
+		DNSNames:   x.DNSNames,
+		Pri:        req.Pri,
+		Ca:         req.Ca,
+	}
+	return id, nil
+}
+
+// domainView 返回域名对外展示的详情,修改尚未生效时状态为 processing,调用方需要持有 s.mu
+func (s *Server) domainView(name string) qiniu.DomainDetail {
+	view := *s.domains[name]
+	if s.processing[name] > 0 {
+		view.OperatingState = qiniu.OperatingStateProcessing
+	}
+	return view
+}
+
+// sortedCertIDs 按上传顺序返回证书 id,调用方需要持有 s.mu
+func (s *Server) sortedCertIDs() []string {
+	ids := make([]string, 0, len(s.certs))
+	for id := range s.certs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// page 根据 marker 和 limit 计算分页范围,marker 为下一页的起始下标
+func page(r *http.Request, total int) (start, end int, next string) {
+	start, _ = strconv.Atoi(r.URL.Query().Get("marker"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+	start = min(max(start, 0), total)
+	end = min(start+limit, total)
+	if end < total {
+		next = strconv.Itoa(end)
+	}
+	return start, end, next
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Reqid", "fake-reqid")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Reqid", "fake-reqid")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{"code": code, "error": message})
+}
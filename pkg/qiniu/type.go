@@ -2,6 +2,7 @@ package qiniu
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,22 +11,31 @@ import (
 	"net/http"
 	"net/url"
 	"reflect"
+	"time"
 )
 
-// 获取域名列表请求,这里只用了一个limit字段,因为不怎么用得到其他的字段，具体请看：https://developer.qiniu.com/fusion/4246/the-domain-name#10
+// 获取域名列表请求,这里只用了marker和limit字段,因为不怎么用得到其他的字段，具体请看：https://developer.qiniu.com/fusion/4246/the-domain-name#10
 type GetDomainReq struct {
-	Limit int `json:"limit"`
+	Marker string `json:"marker"` // 上一页返回的marker,为空表示第一页
+	Limit  int    `json:"limit"`
 }
 
 // 域名列表响应（对应 JSON 根对象）
 type GetDomainResp struct {
+	Marker  string   `json:"marker"` // 下一页的marker,为空表示没有更多数据
 	Domains []Domain `json:"domains"`
 }
 
 // Domain 结构体（对应 domains 数组中的每个对象）
 type Domain struct {
-	Name     string `json:"name"`     //域名
-	CreateAt string `json:"createAt"` // 域名创建时间，格式:RFC3339
+	Name               string `json:"name"`               //域名
+	Type               string `json:"type"`               // 域名类型,如 normal、wildcard、test
+	CName              string `json:"cname"`              // 域名的 CNAME
+	Platform           string `json:"platform"`           // 使用场景,如 web、download、vod
+	Protocol           string `json:"protocol"`           // 访问协议,http 或 https
+	OperatingState     string `json:"operatingState"`     // 域名当前的操作状态,如 success、processing
+	OperatingStateDesc string `json:"operatingStateDesc"` // 操作状态的说明
+	CreateAt           string `json:"createAt"`           // 域名创建时间，格式:RFC3339
 }
 
 type UPSSLCertReq struct {
@@ -36,26 +46,68 @@ type UPSSLCertReq struct {
 }
 
 type GetSSLCertListReq struct {
-	Limit int `json:"limit"`
+	Marker string `json:"marker"` // 上一页返回的marker,为空表示第一页
+	Limit  int    `json:"limit"`
 }
 
+// 获取单个证书的返回,证书的信息都在 cert 字段下
 type GetSSLCertByIDResp struct {
-	Name     string `json:"name"`
-	NotAfter int64  `json:"not_after"`
-	Pri      string `json:"pri"`
-	Ca       string `json:"ca"`
+	Cert CertDetail `json:"cert"`
+}
+
+// 证书详情,在证书列表信息的基础上包含证书内容和私钥
+type CertDetail struct {
+	Cert
+	CommonName string   `json:"common_name"`
+	DNSNames   []string `json:"dnsnames"`
+	Pri        string   `json:"pri"`
+	Ca         string   `json:"ca"`
 }
 
 type GetSSLCertListResp struct {
-	Certs []Cert `json:"certs"`
+	Marker string `json:"marker"` // 下一页的marker,为空表示没有更多数据
+	Certs  []Cert `json:"certs"`
 }
 type GetSSLCertById struct {
 	Certs []Cert `json:"certs"`
 }
 type Cert struct {
-	CertId   string `json:"certid"`
-	Name     string `json:"name"`
-	NotAfter int64  `json:"not_after"`
+	CertId     string `json:"certid"`
+	Name       string `json:"name"`
+	NotBefore  int64  `json:"not_before"`
+	NotAfter   int64  `json:"not_after"`
+	CreateTime int64  `json:"create_time"` // 上传时间
+}
+
+// 域名详情,这里只用到了 https 相关的字段,具体请看：https://developer.qiniu.com/fusion/4246/the-domain-name#11
+type DomainDetail struct {
+	Domain
+	HTTPS HTTPSConf `json:"https"`
+}
+
+// 域名当前的 https 配置
+type HTTPSConf struct {
+	CertID      string `json:"certId"`      // 绑定的证书 id
+	ForceHttps  bool   `json:"forceHttps"`  // 是否强制 http 跳转 https
+	Http2Enable bool   `json:"http2Enable"` // 是否开启 http2
+}
+
+// 绑定证书时的 https 选项,为 nil 的选项沿用域名当前的设置
+type HTTPSOptions struct {
+	ForceHttps  *bool // 是否强制 http 跳转 https
+	Http2Enable *bool // 是否开启 http2
+}
+
+// resolve 用域名当前的设置补全未指定的选项
+func (o HTTPSOptions) resolve(current HTTPSConf) (forceHttps, http2Enable bool) {
+	forceHttps, http2Enable = current.ForceHttps, current.Http2Enable
+	if o.ForceHttps != nil {
+		forceHttps = *o.ForceHttps
+	}
+	if o.Http2Enable != nil {
+		http2Enable = *o.Http2Enable
+	}
+	return forceHttps, http2Enable
 }
 
 type ForceHTTPSReq struct {
@@ -70,10 +122,11 @@ type UPSSLCertResp struct {
 
 //内部通用函数
 
-// 发送 HTTP 请求，自动处理参数方式
-func (c *QiniuClient) newReq(method, path string, data any) ([]byte, error) {
-	var body io.Reader
+// 发送 HTTP 请求，自动处理参数方式,请求前按接口族限流,遇到 429/573 时等待后重试
+func (c *QiniuClient) newReq(ctx context.Context, method, path string, data any) ([]byte, error) {
+	var jsonData []byte
 	urlParams := url.Values{}
+	limiter := c.limiters[familyOf(path)]
 
 	// 解析 struct 并根据 method 选择传参方式
 	if data != nil {
@@ -89,19 +142,60 @@ func (c *QiniuClient) newReq(method, path string, data any) ([]byte, error) {
 			}
 			path = fmt.Sprintf("%s?%s", path, urlParams.Encode())
 		} else {
-			jsonData, err := json.Marshal(data)
+			jsonData, err = json.Marshal(data)
 			if err != nil {
 				return nil, err
 			}
-			body = bytes.NewBuffer(jsonData)
 		}
 	}
 
-	// 构造请求
-	req, err := http.NewRequest(method, QiniuBaseUrl+path, body)
+	for attempt := 0; ; attempt++ {
+		// 等待令牌
+		if limiter != nil {
+			if err := limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+
+		// 构造请求,每次重试都需要重新构造 body 和签名
+		var body io.Reader
+		if jsonData != nil {
+			body = bytes.NewReader(jsonData)
+		}
+		result, resp, err := c.do(ctx, method, path, body)
+		if err != nil {
+			return nil, err
+		}
+
+		//被限流或七牛云过载时等待后重试
+		if isRetryableStatus(resp.StatusCode) && attempt < c.maxRetries {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(retryDelay(resp, attempt)):
+			}
+			continue
+		}
+
+		//非 2xx 的响应统一转换为 QiniuError
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return nil, newQiniuError(resp, result)
+		}
+
+		return result, nil
+	}
+}
+
+// do 发送一次请求并读取响应,超时时间对每次请求单独计算
+func (c *QiniuClient) do(ctx context.Context, method, path string, body io.Reader) ([]byte, *http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	req.Header.Set("User-Agent", c.userAgent)
 
 	//选择请求头
 	if method == http.MethodGet {
@@ -113,22 +207,22 @@ func (c *QiniuClient) newReq(method, path string, data any) ([]byte, error) {
 
 	// 添加 Token 认证
 	if err := c.qiniuClient.AddToken(auth.TokenQBox, req); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	//发送请求
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	defer resp.Body.Close()
 
 	//处理结果并转化为[]byte
 	result, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-
-	return result, nil
+	return result, resp, nil
 }
 
 func (c *QiniuClient) structToMap(data any) (map[string]string, error) {
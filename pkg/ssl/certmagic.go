@@ -2,11 +2,19 @@ package ssl
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
 	"github.com/caddyserver/certmagic"
 )
 
-// NewCertMagicClient 生成 CertMagicClient，用户可以自定义传入 libdns 兼容的 Provider
-func NewCertMagicClient(email, path string, provider Provider) (*CertMagicClient, error) {
+// NewCertMagicClient 生成 CertMagicClient，用户可以自定义传入 libdns 兼容的 Provider。
+// issuers 按顺序尝试,前一个签发失败时使用下一个,为空时使用 Let's Encrypt。
+// 每个客户端拥有独立的 ACME 签发者,不会修改 certmagic 的全局默认值,
+// 同一进程中可以同时存在使用不同 DNS 服务商或 ACME 账号的多个客户端
+func NewCertMagicClient(email, path string, provider Provider, issuers ...Issuer) (*CertMagicClient, error) {
 	if email == "" {
 		email = "admin@yourdomain.com"
 	}
@@ -17,18 +25,34 @@ func NewCertMagicClient(email, path string, provider Provider) (*CertMagicClient
 		return nil, err
 	}
 
-	// 配置 CertMagic
-	certmagic.DefaultACME.Email = email
-	//certmagic.DefaultACME.CA = certmagic.LetsEncryptStagingCA
-	certmagic.DefaultACME.DNS01Solver = &certmagic.DNS01Solver{
+	solver := &certmagic.DNS01Solver{
 		DNSManager: certmagic.DNSManager{
 			DNSProvider: dnsProvider,
 		},
 	}
 
-	// 创建 CertMagic 配置
-	cm := certmagic.NewDefault()
-	cm.Storage = &certmagic.FileStorage{Path: path}
+	if len(issuers) == 0 {
+		issuers = []Issuer{{}}
+	}
+	templates := make([]certmagic.ACMEIssuer, 0, len(issuers))
+	for _, issuer := range issuers {
+		tmpl, err := issuer.template(email, solver)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, tmpl)
+	}
+
+	// 签发时直接调用 ACMEIssuer,不经过 certmagic 的证书缓存和自动续期,
+	// 这里的配置只为签发者提供 ACME 账号的存储位置
+	cm := &certmagic.Config{
+		Storage: &certmagic.FileStorage{Path: path},
+	}
+
+	cm.Issuers = make([]certmagic.Issuer, 0, len(templates))
+	for _, tmpl := range templates {
+		cm.Issuers = append(cm.Issuers, certmagic.NewACMEIssuer(cm, tmpl))
+	}
 
 	return &CertMagicClient{cm: cm}, nil
 }
@@ -37,23 +61,66 @@ type CertMagicClient struct {
 	cm *certmagic.Config
 }
 
-// 获取证书
-func (c *CertMagicClient) ObtainCert(ctx context.Context, domain string) (string, string, error) {
+// ObtainCert 为 names 申请一张包含所有名称的证书,第一个名称作为证书的 CommonName。
+// 每次都会按 opts 生成新的私钥并直接通过 ACME 签发,不会读取或写入 certmagic 的证书缓存,
+// 配置了多个签发者时按顺序尝试,全部失败时返回所有错误
+func (c *CertMagicClient) ObtainCert(ctx context.Context, names []string, opts KeyOptions) (string, string, error) {
+	if len(names) == 0 {
+		return "", "", errors.New("至少需要一个域名")
+	}
 
-	err := c.cm.ObtainCertSync(ctx, domain)
-	if err != nil {
-		return "", "", err
+	issuers := c.acmeIssuers()
+	if len(issuers) == 0 {
+		return "", "", errors.New("没有可用的 ACME 签发者")
 	}
 
-	cert, err := c.cm.CacheManagedCertificate(ctx, domain)
+	key, err := generateKey(opts.Type)
 	if err != nil {
-		return "", "", err
+		return "", "", fmt.Errorf("生成私钥失败: %w", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		return "", "", fmt.Errorf("生成证书请求失败: %w", err)
+	}
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return "", "", fmt.Errorf("解析证书请求失败: %w", err)
 	}
 
-	certPEM, keyPEM, err := c.convertCertToPEM(cert.Certificate)
+	var errs []error
+	var issued *certmagic.IssuedCertificate
+	for _, issuer := range issuers {
+		issued, err = issuer.Issue(ctx, csr)
+		if err == nil {
+			break
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", issuer.CA, err))
+		//收到退出信号时不再尝试后面的签发者
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if issued == nil {
+		return "", "", errors.Join(errs...)
+	}
+
+	keyPEM, err := encodeKeyPEM(key, opts.PKCS8)
 	if err != nil {
 		return "", "", err
 	}
+	return string(issued.Certificate), keyPEM, nil
+}
 
-	return certPEM, keyPEM, nil
+// acmeIssuers 按顺序返回配置中的 ACME 签发者
+func (c *CertMagicClient) acmeIssuers() []*certmagic.ACMEIssuer {
+	var issuers []*certmagic.ACMEIssuer
+	for _, issuer := range c.cm.Issuers {
+		if acme, ok := issuer.(*certmagic.ACMEIssuer); ok {
+			issuers = append(issuers, acme)
+		}
+	}
+	return issuers
 }
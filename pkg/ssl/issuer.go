@@ -0,0 +1,112 @@
+package ssl
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/caddyserver/certmagic"
+	"github.com/mholt/acmez/v3/acme"
+	"os"
+	"strings"
+)
+
+// 常用 CA 的简写,配置中也可以直接填写 ACME 目录地址
+const (
+	LetsEncrypt  = "letsencrypt"
+	ZeroSSL      = "zerossl"
+	GoogleTrust  = "google"
+	defaultCAKey = LetsEncrypt
+)
+
+// knownCAs 简写对应的正式环境与测试环境目录地址,没有测试环境的 CA 留空
+var knownCAs = map[string][2]string{
+	LetsEncrypt: {certmagic.LetsEncryptProductionCA, certmagic.LetsEncryptStagingCA},
+	ZeroSSL:     {certmagic.ZeroSSLProductionCA, ""},
+	GoogleTrust: {certmagic.GoogleTrustProductionCA, certmagic.GoogleTrustStagingCA},
+}
+
+// Issuer 一个 ACME 签发者的配置
+type Issuer struct {
+	CA           string // CA 简写(letsencrypt、zerossl、google)或 ACME 目录地址,为空时使用 Let's Encrypt
+	Staging      bool   // 使用 CA 的测试环境,签发的证书不受浏览器信任,只用于调试
+	EABKeyID     string // External Account Binding 的 key id,ZeroSSL、Google 等 CA 需要
+	EABMACKey    string // External Account Binding 的 HMAC key,base64url 编码
+	TrustedRoots string // 访问 CA 时额外信任的根证书 PEM 文件,用于 Pebble 等自建 CA
+}
+
+// directory 返回签发者的 ACME 目录地址
+func (i Issuer) directory() (string, error) {
+	name := i.CA
+	if name == "" {
+		name = defaultCAKey
+	}
+
+	urls, ok := knownCAs[strings.ToLower(name)]
+	if !ok {
+		if !strings.HasPrefix(name, "https://") && !strings.HasPrefix(name, "http://") {
+			return "", fmt.Errorf("未知的 CA: %s", name)
+		}
+		if i.Staging {
+			return "", fmt.Errorf("自定义的 CA %s 不支持 staging,请直接填写测试环境的目录地址", name)
+		}
+		return name, nil
+	}
+
+	if !i.Staging {
+		return urls[0], nil
+	}
+	if urls[1] == "" {
+		return "", fmt.Errorf("CA %s 没有测试环境", name)
+	}
+	return urls[1], nil
+}
+
+// template 生成 certmagic 的 ACMEIssuer 模板
+func (i Issuer) template(email string, solver *certmagic.DNS01Solver) (certmagic.ACMEIssuer, error) {
+	ca, err := i.directory()
+	if err != nil {
+		return certmagic.ACMEIssuer{}, err
+	}
+
+	//TestCA 与 CA 相同,失败重试时不会切换到其他 CA 的测试环境
+	tmpl := certmagic.ACMEIssuer{
+		CA:          ca,
+		TestCA:      ca,
+		Email:       email,
+		Agreed:      true,
+		DNS01Solver: solver,
+	}
+
+	switch {
+	case i.EABKeyID != "" && i.EABMACKey != "":
+		tmpl.ExternalAccount = &acme.EAB{KeyID: i.EABKeyID, MACKey: i.EABMACKey}
+	case i.EABKeyID != "" || i.EABMACKey != "":
+		return certmagic.ACMEIssuer{}, errors.New("EAB 的 keyID 和 macKey 需要同时配置")
+	}
+
+	if i.TrustedRoots != "" {
+		pool, err := loadTrustedRoots(i.TrustedRoots)
+		if err != nil {
+			return certmagic.ACMEIssuer{}, err
+		}
+		tmpl.TrustedRoots = pool
+	}
+	return tmpl, nil
+}
+
+// loadTrustedRoots 读取 PEM 文件中的根证书,同时保留系统的根证书
+func loadTrustedRoots(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取根证书 %s 失败: %w", path, err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("根证书 %s 中没有有效的证书", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,50 @@
+package ssl
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"strings"
+)
+
+// 支持的证书私钥类型
+const (
+	KeyRSA2048   = "rsa2048"
+	KeyRSA4096   = "rsa4096"
+	KeyECDSAP256 = "p256"
+	KeyECDSAP384 = "p384"
+)
+
+// KeyOptions 申请证书时使用的私钥类型与编码格式
+type KeyOptions struct {
+	Type  string // 私钥类型,为空时使用 ECDSA P-256
+	PKCS8 bool   // 是否以 PKCS#8 编码私钥,否则 RSA 使用 PKCS#1,ECDSA 使用 SEC 1
+}
+
+// generateKey 按类型生成私钥
+func generateKey(keyType string) (crypto.Signer, error) {
+	switch strings.ToLower(keyType) {
+	case "", KeyECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyRSA2048:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case KeyRSA4096:
+		return rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, fmt.Errorf("未知的私钥类型: %s", keyType)
+	}
+}
+
+// ValidKeyType 判断私钥类型是否受支持
+func ValidKeyType(keyType string) bool {
+	switch strings.ToLower(keyType) {
+	case "", KeyECDSAP256, KeyECDSAP384, KeyRSA2048, KeyRSA4096:
+		return true
+	}
+	return false
+}
@@ -1,15 +1,27 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"github.com/muxi-Infra/autossl-qiniuyun/config" // 替换为你的实际包路径
+	"github.com/muxi-Infra/autossl-qiniuyun/cron"
+	"github.com/muxi-Infra/autossl-qiniuyun/dao"
+	"gorm.io/gorm"
+	"time"
 )
 
+var ErrRunNotFound = errors.New("执行记录不存在")
+
 // Service 结构体
-type Service struct{}
+type Service struct {
+	corn cron.Corn
+}
 
 // NewService 创建 Service 实例
-func NewService() *Service {
-	return &Service{}
+func NewService(corn cron.Corn) *Service {
+	return &Service{
+		corn: corn,
+	}
 }
 
 // GetAllConfigsAsYAML 获取所有配置（返回 YAML 字符串）
@@ -25,3 +37,53 @@ func (s *Service) OverwriteConfigsFromYAML(yamlData string) error {
 	}
 	return config.WriteConfigToFile(newConfig)
 }
+
+// GetSchedule 获取当前的调度配置以及下一次计划执行的时间
+func (s *Service) GetSchedule() (config.ScheduleConf, time.Time) {
+	return config.GetCronConfig().Schedule, s.corn.NextRun()
+}
+
+// ListRuns 按时间倒序分页获取续期执行记录
+func (s *Service) ListRuns(limit, offset int) ([]dao.Run, int64, error) {
+	return s.corn.ListRuns(limit, offset)
+}
+
+// GetRun 获取单条续期执行记录
+func (s *Service) GetRun(id uint) (*dao.Run, error) {
+	run, err := s.corn.GetRun(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRunNotFound
+	}
+	return run, err
+}
+
+// GetRenewal 获取手动续期任务的执行记录,其他方式触发的执行记录视为不存在
+func (s *Service) GetRenewal(id uint) (*dao.Run, error) {
+	run, err := s.GetRun(id)
+	if err != nil {
+		return nil, err
+	}
+	if run.Trigger != cron.TriggerManual {
+		return nil, ErrRunNotFound
+	}
+	return run, nil
+}
+
+// Plan 预演一轮续期任务
+func (s *Service) Plan(ctx context.Context) ([]cron.GroupPlan, error) {
+	return s.corn.Plan(ctx)
+}
+
+// Cleanup 清理七牛云上无用的证书
+func (s *Service) Cleanup(ctx context.Context, dryRun bool) (*cron.CleanupReport, error) {
+	return s.corn.Cleanup(ctx, dryRun)
+}
+
+// Renew 立即对指定的父域名或域名执行续期,返回任务 id
+func (s *Service) Renew(fatherDomain string, domains []string, force bool) (uint, error) {
+	return s.corn.Renew(cron.RenewRequest{
+		FatherDomain: fatherDomain,
+		Domains:      domains,
+		Force:        force,
+	})
+}
@@ -19,7 +19,7 @@ import (
 func InitApp() *App {
 	qiniuSSL := cron.NewQiniuSSL()
 	corn := cron.NewCorn(qiniuSSL)
-	serviceService := service.NewService()
+	serviceService := service.NewService(corn)
 	engine := router.InitRouter(serviceService)
 	app := NewApp(corn, engine)
 	return app